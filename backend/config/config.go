@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
@@ -12,6 +14,29 @@ type Config struct {
 	DBName     string
 	JWTSecret  string
 	ServerPort string
+
+	// 加密保存 API Key 的主密钥，文件优先，必须配置其中之一
+	// 支持多个密钥 ("<ID>:<密钥>"，逗号或换行分隔)，第一个用于加密，其余用于解密轮换前的数据
+	SecretKey     string
+	SecretKeyFile string
+
+	// 上游 AI 服务的 HTTP 连接配置
+	UpstreamDialTimeout           time.Duration
+	UpstreamTLSHandshakeTimeout   time.Duration
+	UpstreamResponseHeaderTimeout time.Duration
+	UpstreamIdleConnTimeout       time.Duration
+	UpstreamMaxIdleConnsPerHost   int
+	UpstreamProxy                 string        // 全局出站代理 (http/https/socks5)，Provider 可单独覆盖
+	UpstreamCAFile                string        // 额外信任的 CA 证书文件 (PEM)
+	UpstreamCircuitFailures       int           // 连续失败多少次后熔断 Provider
+	UpstreamCircuitOpenDuration   time.Duration // 熔断持续时间，之后放行探测请求
+
+	// 代理接口的全局限流默认值，0 表示不限制，可按角色或用户覆盖
+	RateLimitRPM int
+	RateLimitTPM int
+
+	// 定时同步上游模型列表的间隔，只同步开启了 autoImportModels 的 Provider，0 表示不同步
+	ModelSyncInterval time.Duration
 }
 
 func Load() *Config {
@@ -23,6 +48,24 @@ func Load() *Config {
 		DBName:     getEnv("DB_NAME", "chatbox"),
 		JWTSecret:  getEnv("JWT_SECRET", "change-me-in-production"),
 		ServerPort: getEnv("SERVER_PORT", "8080"),
+
+		SecretKey:     getEnv("SECRET_KEY", ""),
+		SecretKeyFile: getEnv("SECRET_KEY_FILE", ""),
+
+		UpstreamDialTimeout:           getEnvDuration("UPSTREAM_DIAL_TIMEOUT", 10*time.Second),
+		UpstreamTLSHandshakeTimeout:   getEnvDuration("UPSTREAM_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		UpstreamResponseHeaderTimeout: getEnvDuration("UPSTREAM_RESPONSE_HEADER_TIMEOUT", 5*time.Minute),
+		UpstreamIdleConnTimeout:       getEnvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+		UpstreamMaxIdleConnsPerHost:   getEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 32),
+		UpstreamProxy:                 getEnv("UPSTREAM_PROXY", ""),
+		UpstreamCAFile:                getEnv("UPSTREAM_CA_FILE", ""),
+		UpstreamCircuitFailures:       getEnvInt("UPSTREAM_CIRCUIT_FAILURES", 5),
+		UpstreamCircuitOpenDuration:   getEnvDuration("UPSTREAM_CIRCUIT_OPEN_DURATION", 30*time.Second),
+
+		RateLimitRPM: getEnvInt("RATE_LIMIT_RPM", 0),
+		RateLimitTPM: getEnvInt("RATE_LIMIT_TPM", 0),
+
+		ModelSyncInterval: getEnvDuration("MODEL_SYNC_INTERVAL", 24*time.Hour),
 	}
 }
 
@@ -32,3 +75,23 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvDuration 读取时长配置 (例如 30s, 5m)，格式错误时使用默认值
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+	}
+	return defaultValue
+}
+
+// getEnvInt 读取整数配置，格式错误时使用默认值
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+	}
+	return defaultValue
+}
@@ -3,36 +3,42 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"chatbox-backend/models"
+	"chatbox-backend/upstream"
 
 	"github.com/gin-gonic/gin"
 )
 
 type CreateProviderRequest struct {
-	ProviderID     string                 `json:"providerId" binding:"required"`
-	Name           string                 `json:"name" binding:"required"`
-	APIStyle       string                 `json:"apiStyle" binding:"required"` // openai | google | anthropic
-	APIHost        string                 `json:"apiHost"`
-	APIKey         string                 `json:"apiKey"`
-	Enabled        bool                   `json:"enabled"`
-	AllowCustomKey bool                   `json:"allowCustomKey"`
-	Models         []models.ProviderModel `json:"models"`
-	IsDefault      bool                   `json:"isDefault"`
-	SortOrder      int                    `json:"sortOrder"`
+	ProviderID       string                 `json:"providerId" binding:"required"`
+	Name             string                 `json:"name" binding:"required"`
+	APIStyle         string                 `json:"apiStyle" binding:"required"` // openai | google | anthropic
+	APIHost          string                 `json:"apiHost"`
+	APIKey           string                 `json:"apiKey"`
+	ProxyURL         string                 `json:"proxyUrl"` // http(s):// 或 socks5://，为空时使用全局配置
+	Enabled          bool                   `json:"enabled"`
+	AllowCustomKey   bool                   `json:"allowCustomKey"`
+	Models           []models.ProviderModel `json:"models"`
+	AutoImportModels bool                   `json:"autoImportModels"`
+	IsDefault        bool                   `json:"isDefault"`
+	SortOrder        int                    `json:"sortOrder"`
 }
 
 type UpdateProviderRequest struct {
-	ProviderID     string                 `json:"providerId"`
-	Name           string                 `json:"name"`
-	APIStyle       string                 `json:"apiStyle"`
-	APIHost        string                 `json:"apiHost"`
-	APIKey         string                 `json:"apiKey"`
-	Enabled        *bool                  `json:"enabled"`
-	AllowCustomKey *bool                  `json:"allowCustomKey"`
-	Models         []models.ProviderModel `json:"models"`
-	IsDefault      *bool                  `json:"isDefault"`
-	SortOrder      *int                   `json:"sortOrder"`
+	ProviderID       string                 `json:"providerId"`
+	Name             string                 `json:"name"`
+	APIStyle         string                 `json:"apiStyle"`
+	APIHost          string                 `json:"apiHost"`
+	APIKey           string                 `json:"apiKey"`   // 只写，为空时保留原 Key，清除 Key 使用 DELETE /providers/:id/api-key
+	ProxyURL         *string                `json:"proxyUrl"` // 传空字符串清除 Provider 级代理
+	Enabled          *bool                  `json:"enabled"`
+	AllowCustomKey   *bool                  `json:"allowCustomKey"`
+	Models           []models.ProviderModel `json:"models"`
+	AutoImportModels *bool                  `json:"autoImportModels"`
+	IsDefault        *bool                  `json:"isDefault"`
+	SortOrder        *int                   `json:"sortOrder"`
 }
 
 // GetPublicProviders 获取公开的 Provider 列表 (普通用户)
@@ -43,10 +49,18 @@ func GetPublicProviders(c *gin.Context) {
 		return
 	}
 
+	keyPools, err := models.GetAllEnabledProviderKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get providers"})
+		return
+	}
+
 	// 转换为公开格式 (隐藏 API Key)
 	var publicProviders []models.PublicProvider
 	for _, p := range providers {
-		publicProviders = append(publicProviders, p.ToPublic())
+		public := p.ToPublic()
+		public.HasSystemKey = len(providerKeys(&p, keyPools[p.ID])) > 0
+		publicProviders = append(publicProviders, public)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"providers": publicProviders})
@@ -71,17 +85,27 @@ func AdminCreateProvider(c *gin.Context) {
 		return
 	}
 
+	if err := upstream.ValidateProxyURL(req.ProxyURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !checkAPIKeyInput(c, req.APIKey) {
+		return
+	}
+
 	provider := &models.Provider{
-		ProviderID:     req.ProviderID,
-		Name:           req.Name,
-		APIStyle:       req.APIStyle,
-		APIHost:        req.APIHost,
-		APIKey:         req.APIKey,
-		Enabled:        req.Enabled,
-		AllowCustomKey: req.AllowCustomKey,
-		Models:         req.Models,
-		IsDefault:      req.IsDefault,
-		SortOrder:      req.SortOrder,
+		ProviderID:       req.ProviderID,
+		Name:             req.Name,
+		APIStyle:         req.APIStyle,
+		APIHost:          req.APIHost,
+		APIKey:           req.APIKey,
+		ProxyURL:         req.ProxyURL,
+		Enabled:          req.Enabled,
+		AllowCustomKey:   req.AllowCustomKey,
+		Models:           req.Models,
+		AutoImportModels: req.AutoImportModels,
+		IsDefault:        req.IsDefault,
+		SortOrder:        req.SortOrder,
 	}
 
 	created, err := models.CreateProvider(provider)
@@ -129,8 +153,18 @@ func AdminUpdateProvider(c *gin.Context) {
 		provider.APIHost = req.APIHost
 	}
 	if req.APIKey != "" {
+		if !checkAPIKeyInput(c, req.APIKey) {
+			return
+		}
 		provider.APIKey = req.APIKey
 	}
+	if req.ProxyURL != nil {
+		if err := upstream.ValidateProxyURL(*req.ProxyURL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+		provider.ProxyURL = *req.ProxyURL
+	}
 	if req.Enabled != nil {
 		provider.Enabled = *req.Enabled
 	}
@@ -140,6 +174,9 @@ func AdminUpdateProvider(c *gin.Context) {
 	if req.Models != nil {
 		provider.Models = req.Models
 	}
+	if req.AutoImportModels != nil {
+		provider.AutoImportModels = *req.AutoImportModels
+	}
 	if req.IsDefault != nil {
 		provider.IsDefault = *req.IsDefault
 	}
@@ -148,7 +185,7 @@ func AdminUpdateProvider(c *gin.Context) {
 	}
 
 	if err := models.UpdateProvider(provider); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update provider"})
+		writeKeyUpdateError(c, err, "Failed to update provider")
 		return
 	}
 
@@ -180,6 +217,29 @@ func AdminDeleteProvider(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Provider deleted"})
 }
 
+// AdminClearProviderAPIKey 清除 Provider 的 API Key (管理员)，Key 池中的 Key 不受影响
+func AdminClearProviderAPIKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	provider, err := models.GetProviderByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	provider.APIKey = ""
+	if err := models.UpdateProvider(provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update provider"})
+		return
+	}
+
+	c.JSON(http.StatusOK, provider)
+}
+
 // AdminGetUsers 获取用户列表 (管理员)
 func AdminGetUsers(c *gin.Context) {
 	users, err := models.GetAllUsers()
@@ -196,3 +256,35 @@ func AdminGetUsers(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"users": responses})
 }
+
+type UpdateUserGroupRequest struct {
+	Group string `json:"group"` // 空字符串表示移出分组
+}
+
+// AdminUpdateUserGroup 设置用户分组 (管理员)
+func AdminUpdateUserGroup(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req UpdateUserGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if _, err := models.GetUserByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := models.UpdateUserGroup(id, strings.TrimSpace(req.Group)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user group"})
+		return
+	}
+
+	updated, _ := models.GetUserByID(id)
+	c.JSON(http.StatusOK, updated.ToResponse())
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatbox-backend/models"
+	"chatbox-backend/upstream"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyHealth Key 池中单个 Key 的调用统计
+type KeyHealth struct {
+	ID    int64          `json:"id"` // 0 为 Provider 上配置的默认 Key
+	Name  string         `json:"name"`
+	Stats upstream.Stats `json:"stats"`
+}
+
+// ProviderHealthResponse Provider 的熔断状态与调用统计
+type ProviderHealthResponse struct {
+	ID         int64          `json:"id"`
+	ProviderID string         `json:"providerId"`
+	Name       string         `json:"name"`
+	Enabled    bool           `json:"enabled"`
+	State      string         `json:"state"` // closed | open | half_open
+	OpenedAt   *time.Time     `json:"openedAt,omitempty"`
+	RetryAt    *time.Time     `json:"retryAt,omitempty"`
+	Stats      upstream.Stats `json:"stats"`
+	Keys       []KeyHealth    `json:"keys"`
+}
+
+// AdminGetUpstreamHealth 获取各 Provider 的熔断状态与调用统计 (管理员)
+// 统计保存在内存中，服务重启后清零
+func AdminGetUpstreamHealth(c *gin.Context) {
+	providers, err := models.GetAllProviders()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get providers"})
+		return
+	}
+
+	snapshot := upstream.HealthSnapshot()
+	result := make([]ProviderHealthResponse, 0, len(providers))
+	for _, p := range providers {
+		h, ok := snapshot[p.ID]
+		if !ok {
+			h = upstream.ProviderHealth{State: upstream.CircuitClosed}
+		}
+
+		item := ProviderHealthResponse{
+			ID:         p.ID,
+			ProviderID: p.ProviderID,
+			Name:       p.Name,
+			Enabled:    p.Enabled,
+			State:      h.State,
+			OpenedAt:   h.OpenedAt,
+			RetryAt:    h.RetryAt,
+			Stats:      h.Stats,
+			Keys:       []KeyHealth{},
+		}
+
+		if p.APIKey != "" {
+			item.Keys = append(item.Keys, KeyHealth{ID: 0, Name: "default", Stats: h.Keys[0]})
+		}
+		pool, err := models.GetProviderKeys(p.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+			return
+		}
+		for _, k := range pool {
+			item.Keys = append(item.Keys, KeyHealth{ID: k.ID, Name: k.Name, Stats: h.Keys[k.ID]})
+		}
+
+		result = append(result, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"providers": result})
+}
+
+// AdminResetProviderHealth 清除 Provider 的熔断状态与统计 (管理员)
+func AdminResetProviderHealth(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	if _, err := models.GetProviderByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	upstream.ResetHealth(id)
+	c.JSON(http.StatusOK, gin.H{"message": "Provider health reset"})
+}
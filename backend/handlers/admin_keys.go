@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"chatbox-backend/models"
+	"chatbox-backend/secret"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CreateProviderKeyRequest struct {
+	Name    string `json:"name"`
+	APIKey  string `json:"apiKey" binding:"required"`
+	Weight  int    `json:"weight"`
+	Enabled *bool  `json:"enabled"`
+}
+
+type UpdateProviderKeyRequest struct {
+	Name    *string `json:"name"`
+	APIKey  string  `json:"apiKey"`
+	Weight  *int    `json:"weight"`
+	Enabled *bool   `json:"enabled"`
+}
+
+// checkAPIKeyInput 拒绝带有密文前缀的 API Key，返回 false 时已写入错误响应
+// 保存时已加密的值会被原样保留，因此不能接受客户端提交的 "enc:" 开头的值
+func checkAPIKeyInput(c *gin.Context, apiKey string) bool {
+	if secret.IsEncrypted(apiKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: apiKey must not start with enc:"})
+		return false
+	}
+	return true
+}
+
+// writeKeyUpdateError 写入更新失败的错误响应
+// 数据库中的 Key 由已从 SECRET_KEY 删除的旧密钥加密时无法原样保存，提示管理员重新填写 Key 或恢复旧密钥
+func writeKeyUpdateError(c *gin.Context, err error, message string) {
+	if errors.Is(err, models.ErrInvalidAPIKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The stored API key cannot be decrypted with the configured SECRET_KEY: enter the API key again, or add the key it was encrypted with back to SECRET_KEY"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// getProviderKeyParams 解析路径中的 Provider ID 与 Key ID，并确认 Key 属于该 Provider
+// 返回 false 时已写入错误响应
+func getProviderKeyParams(c *gin.Context) (*models.ProviderKey, bool) {
+	providerID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return nil, false
+	}
+	keyID, err := strconv.ParseInt(c.Param("keyId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return nil, false
+	}
+
+	key, err := models.GetProviderKeyByID(keyID)
+	if err != nil || key.ProviderID != providerID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return nil, false
+	}
+	return key, true
+}
+
+// AdminGetProviderKeys 获取 Provider 的 API Key 池 (管理员)
+func AdminGetProviderKeys(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	if _, err := models.GetProviderByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	keys, err := models.GetProviderKeys(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// AdminCreateProviderKey 向 Provider 的 Key 池添加 API Key (管理员)
+func AdminCreateProviderKey(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	if _, err := models.GetProviderByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	var req CreateProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !checkAPIKeyInput(c, req.APIKey) {
+		return
+	}
+
+	key := &models.ProviderKey{
+		ProviderID: id,
+		Name:       req.Name,
+		APIKey:     req.APIKey,
+		Weight:     req.Weight,
+		Enabled:    req.Enabled == nil || *req.Enabled,
+	}
+	if key.Weight <= 0 {
+		key.Weight = 1
+	}
+
+	created, err := models.CreateProviderKey(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// AdminUpdateProviderKey 更新 Key 池中的 API Key (管理员)
+func AdminUpdateProviderKey(c *gin.Context) {
+	key, ok := getProviderKeyParams(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	// 更新字段
+	if req.Name != nil {
+		key.Name = *req.Name
+	}
+	if req.APIKey != "" {
+		if !checkAPIKeyInput(c, req.APIKey) {
+			return
+		}
+		key.APIKey = req.APIKey
+	}
+	if req.Weight != nil {
+		if *req.Weight <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "weight must be greater than 0"})
+			return
+		}
+		key.Weight = *req.Weight
+	}
+	if req.Enabled != nil {
+		key.Enabled = *req.Enabled
+	}
+
+	if err := models.UpdateProviderKey(key); err != nil {
+		writeKeyUpdateError(c, err, "Failed to update API key")
+		return
+	}
+
+	updated, _ := models.GetProviderKeyByID(key.ID)
+	c.JSON(http.StatusOK, updated)
+}
+
+// AdminDeleteProviderKey 从 Key 池删除 API Key (管理员)
+func AdminDeleteProviderKey(c *gin.Context) {
+	key, ok := getProviderKeyParams(c)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteProviderKey(key.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key deleted"})
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatbox-backend/models"
+	"chatbox-backend/secret"
+	"chatbox-backend/upstream"
+
+	"github.com/gin-gonic/gin"
+)
+
+// providerCheckTimeout 连通性测试的超时时间
+const providerCheckTimeout = 15 * time.Second
+
+// providerCheckPaths 各 API 风格用于连通性测试的接口：只列出模型，需要认证但不消耗 tokens
+var providerCheckPaths = map[string]string{
+	"openai":    "/v1/models",
+	"anthropic": "/v1/models?limit=1",
+	"google":    "/v1beta/models?pageSize=1",
+}
+
+// 连通性测试的认证状态
+const (
+	authStatusOK          = "ok"           // 上游接受了 Key
+	authStatusInvalid     = "invalid"      // Key 无效 (401，或 Google 返回的 API key not valid)
+	authStatusForbidden   = "forbidden"    // Key 有效但没有权限 (403)
+	authStatusRateLimited = "rate_limited" // Key 被限流或额度用尽 (429)
+	authStatusMissing     = "missing"      // 没有可用的 Key
+	authStatusUnknown     = "unknown"      // 网络错误或其他状态码，无法判断
+)
+
+// ProviderCheckResult Provider 连通性测试结果
+type ProviderCheckResult struct {
+	URL        string `json:"url"`
+	KeyID      *int64 `json:"keyId,omitempty"` // 使用的 Key，0 为 Provider 上配置的默认 Key，测试未保存的配置时为空
+	Reachable  bool   `json:"reachable"`       // 是否收到上游的 HTTP 响应
+	AuthStatus string `json:"authStatus"`      // ok | invalid | forbidden | rate_limited | missing | unknown
+	StatusCode int    `json:"statusCode,omitempty"`
+	LatencyMs  int64  `json:"latencyMs"`
+	Error      string `json:"error,omitempty"` // 网络错误或上游返回的错误信息
+}
+
+// CheckProviderRequest 测试未保存的 Provider 配置
+// 传入 id 且 apiKey 为空时使用该 Provider 已保存的 Key，用于编辑表单 (Key 只写，不会回显)；
+// 为避免 Key 被发往其他地址，此时 apiStyle、apiHost 与 proxyUrl 必须与已保存的配置一致
+type CheckProviderRequest struct {
+	ID       int64  `json:"id"`
+	APIStyle string `json:"apiStyle" binding:"required"` // openai | google | anthropic
+	APIHost  string `json:"apiHost"`
+	APIKey   string `json:"apiKey"`
+	ProxyURL string `json:"proxyUrl"`
+}
+
+// AdminCheckProvider 测试已保存 Provider 的连通性与 Key 是否有效 (管理员)
+// 查询参数 keyId 指定测试的 Key (0 为默认 Key)，不传时使用默认 Key 或 Key 池中第一个启用的 Key
+// 测试请求不计入健康统计，Provider 熔断时也会发送
+func AdminCheckProvider(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	provider, err := models.GetProviderByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+
+	pool, err := models.GetProviderKeys(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+		return
+	}
+
+	var key *upstream.Key
+	if keyIDStr := c.Query("keyId"); keyIDStr != "" {
+		keyID, err := strconv.ParseInt(keyIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+			return
+		}
+		if keyID == 0 && provider.APIKey != "" {
+			key = &upstream.Key{ID: 0, Value: provider.APIKey}
+		}
+		for _, k := range pool {
+			if keyID != 0 && k.ID == keyID && k.APIKey != "" {
+				key = &upstream.Key{ID: k.ID, Value: k.APIKey}
+			}
+		}
+		if key == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+	} else if keys := providerKeys(provider, pool); len(keys) > 0 {
+		key = &keys[0]
+	}
+
+	apiKey := ""
+	if key != nil {
+		if apiKey, err = secret.Reveal(key.Value); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt API key"})
+			return
+		}
+	}
+
+	result := checkProvider(c.Request.Context(), provider, apiKey)
+	if key != nil {
+		result.KeyID = &key.ID
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// AdminCheckProviderConfig 测试未保存的 Provider 配置 (管理员)
+func AdminCheckProviderConfig(c *gin.Context) {
+	var req CheckProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if _, ok := providerCheckPaths[req.APIStyle]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "apiStyle must be openai, google or anthropic"})
+		return
+	}
+	if err := upstream.ValidateProxyURL(req.ProxyURL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	apiKey := req.APIKey
+	if apiKey == "" && req.ID != 0 {
+		saved, err := models.GetProviderByID(req.ID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+			return
+		}
+		if saved.APIStyle != req.APIStyle || saved.APIHost != req.APIHost || saved.ProxyURL != req.ProxyURL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "apiKey is required when apiStyle, apiHost or proxyUrl differs from the saved provider"})
+			return
+		}
+		if apiKey, err = secret.Reveal(saved.APIKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt API key"})
+			return
+		}
+	}
+
+	provider := &models.Provider{
+		APIStyle: req.APIStyle,
+		APIHost:  req.APIHost,
+		ProxyURL: req.ProxyURL,
+	}
+	c.JSON(http.StatusOK, checkProvider(c.Request.Context(), provider, apiKey))
+}
+
+// checkProvider 按 Provider 的 API 风格发送一次列出模型的请求，根据响应判断连通性与认证状态
+func checkProvider(ctx context.Context, p *models.Provider, apiKey string) *ProviderCheckResult {
+	t := &proxyTarget{Provider: p}
+	path, ok := providerCheckPaths[t.APIStyle()]
+	if !ok {
+		path = providerCheckPaths["openai"]
+	}
+	result := &ProviderCheckResult{URL: upstreamURL(t, path), AuthStatus: authStatusUnknown}
+
+	client, err := upstream.Client(p.ProxyURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, providerCheckTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", result.URL, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	if apiKey != "" {
+		setUpstreamAuth(req, t, apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "Request timed out after " + providerCheckTimeout.String()
+		} else {
+			result.Error = err.Error()
+		}
+		if apiKey == "" {
+			result.AuthStatus = authStatusMissing
+		}
+		return result
+	}
+	defer resp.Body.Close()
+
+	result.Reachable = true
+	result.StatusCode = resp.StatusCode
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		result.Error = upstreamErrorMessage(body)
+		// 截断到 500 字节，去掉被截断的不完整字符
+		if len(result.Error) > 500 {
+			result.Error = strings.ToValidUTF8(result.Error[:500], "")
+		}
+		if result.Error == "" {
+			result.Error = http.StatusText(resp.StatusCode)
+		}
+	}
+
+	switch {
+	case apiKey == "":
+		result.AuthStatus = authStatusMissing
+	case resp.StatusCode < http.StatusBadRequest:
+		result.AuthStatus = authStatusOK
+	case resp.StatusCode == http.StatusUnauthorized:
+		result.AuthStatus = authStatusInvalid
+	case resp.StatusCode == http.StatusForbidden:
+		result.AuthStatus = authStatusForbidden
+	case resp.StatusCode == http.StatusTooManyRequests:
+		result.AuthStatus = authStatusRateLimited
+	case resp.StatusCode == http.StatusBadRequest && strings.Contains(result.Error, "API key"):
+		// Google 对无效 Key 返回 400 INVALID_ARGUMENT
+		result.AuthStatus = authStatusInvalid
+	}
+	return result
+}
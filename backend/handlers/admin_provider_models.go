@@ -0,0 +1,479 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// modelDiscoveryTimeout 拉取上游模型列表 (包括翻页) 的超时时间
+const modelDiscoveryTimeout = 30 * time.Second
+
+// maxModelListPages 拉取模型列表时最多翻页的次数
+const maxModelListPages = 20
+
+var errNoProviderKey = errors.New("provider has no API key")
+
+// upstreamModel 上游模型列表中的一项，各 API 风格没有返回的字段为空
+type upstreamModel struct {
+	ID            string
+	DisplayName   string
+	Methods       []string // Google 的 supportedGenerationMethods
+	ContextWindow int
+	MaxOutput     int
+	Thinking      bool // Google 返回的是否支持思考
+}
+
+// ModelDiscoveryResult 上游模型列表与 Provider 已配置模型的对比结果
+type ModelDiscoveryResult struct {
+	ID          int64                  `json:"id"`
+	Upstream    int                    `json:"upstream"`    // 上游返回的模型数量
+	New         []models.ProviderModel `json:"new"`         // 上游有、Provider 未配置的模型，已推断类型与能力
+	Existing    []string               `json:"existing"`    // 两边都有的模型
+	Missing     []string               `json:"missing"`     // Provider 配置了但上游没有返回的模型 (可能已下线或改名)
+	Unsupported []string               `json:"unsupported"` // 代理不支持的模型 (语音、审核等)，不会导入
+}
+
+type ImportProviderModelsRequest struct {
+	ModelIDs []string `json:"modelIds"` // 要导入的模型，为空时导入所有新模型
+}
+
+// AdminDiscoverProviderModels 拉取上游的模型列表并与 Provider 已配置的模型对比 (管理员)
+func AdminDiscoverProviderModels(c *gin.Context) {
+	provider, ok := getProviderParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := discoverProviderModels(c.Request.Context(), provider)
+	if err != nil {
+		writeDiscoveryError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// AdminImportProviderModels 将上游新增的模型导入 Provider 的模型列表 (管理员)
+// 导入的模型使用推断的 Type，推断的能力保存在 SuggestedCapabilities，价格与 Capabilities 等需要管理员补充
+func AdminImportProviderModels(c *gin.Context) {
+	provider, ok := getProviderParam(c)
+	if !ok {
+		return
+	}
+
+	var req ImportProviderModelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	result, err := discoverProviderModels(c.Request.Context(), provider)
+	if err != nil {
+		writeDiscoveryError(c, err)
+		return
+	}
+
+	selected := result.New
+	skipped := []string{}
+	if len(req.ModelIDs) > 0 {
+		discovered := make(map[string]models.ProviderModel, len(result.New))
+		for _, m := range result.New {
+			discovered[m.ModelID] = m
+		}
+		selected = nil
+		for _, id := range req.ModelIDs {
+			if m, ok := discovered[id]; ok {
+				selected = append(selected, m)
+			} else {
+				skipped = append(skipped, id)
+			}
+		}
+	}
+
+	imported, err := models.AddProviderModels(provider.ID, selected)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import models"})
+		return
+	}
+	if imported == nil {
+		imported = []models.ProviderModel{}
+	}
+
+	updated, _ := models.GetProviderByID(provider.ID)
+	c.JSON(http.StatusOK, gin.H{"imported": imported, "skipped": skipped, "provider": updated})
+}
+
+// getProviderParam 解析路径中的 Provider ID 并加载 Provider，返回 false 时已写入错误响应
+func getProviderParam(c *gin.Context) (*models.Provider, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return nil, false
+	}
+
+	provider, err := models.GetProviderByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return nil, false
+	}
+	return provider, true
+}
+
+func writeDiscoveryError(c *gin.Context, err error) {
+	if errors.Is(err, errNoProviderKey) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider has no API key configured"})
+		return
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to list upstream models: " + err.Error()})
+}
+
+// StartModelSync 按间隔同步开启了 autoImportModels 的 Provider，自动导入上游新增的模型
+// interval 为 0 时不启动；ctx 取消后停止同步 (进行中的上游请求随之取消)
+func StartModelSync(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	log.Printf("[ModelSync] syncing upstream models every %s", interval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				syncProviderModels(ctx)
+			}
+		}
+	}()
+}
+
+// syncProviderModels 对启用且开启了 autoImportModels 的 Provider 执行一次同步，单个 Provider 失败不影响其他 Provider
+func syncProviderModels(ctx context.Context) {
+	providers, err := models.GetEnabledProviders()
+	if err != nil {
+		log.Printf("[ModelSync] failed to get providers: %v", err)
+		return
+	}
+
+	for i := range providers {
+		p := &providers[i]
+		if ctx.Err() != nil {
+			return
+		}
+		if !p.AutoImportModels {
+			continue
+		}
+
+		result, err := discoverProviderModels(ctx, p)
+		if err != nil {
+			log.Printf("[ModelSync] provider=%s failed to list models: %v", p.ProviderID, err)
+			continue
+		}
+		imported, err := models.AddProviderModels(p.ID, result.New)
+		if err != nil {
+			log.Printf("[ModelSync] provider=%s failed to import models: %v", p.ProviderID, err)
+			continue
+		}
+		if len(imported) > 0 || len(result.Missing) > 0 {
+			ids := make([]string, len(imported))
+			for i, m := range imported {
+				ids[i] = m.ModelID
+			}
+			log.Printf("[ModelSync] provider=%s imported=%v missing upstream=%v", p.ProviderID, ids, result.Missing)
+		}
+	}
+}
+
+// discoverProviderModels 使用 Provider 的系统 Key 拉取上游模型列表，并与已配置的模型对比
+// Key 的选择与失败切换和代理请求相同 (doUpstream)
+func discoverProviderModels(ctx context.Context, p *models.Provider) (*ModelDiscoveryResult, error) {
+	pool, err := models.GetEnabledProviderKeys(p.ID)
+	if err != nil {
+		return nil, err
+	}
+	keys := providerKeys(p, pool)
+	if len(keys) == 0 {
+		return nil, errNoProviderKey
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, modelDiscoveryTimeout)
+	defer cancel()
+	t := &proxyTarget{Provider: p, Keys: keys}
+	listed, err := listUpstreamModels(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ModelDiscoveryResult{
+		ID:          p.ID,
+		Upstream:    len(listed),
+		New:         []models.ProviderModel{},
+		Existing:    []string{},
+		Missing:     []string{},
+		Unsupported: []string{},
+	}
+	offered := make(map[string]bool, len(listed))
+	for _, u := range listed {
+		offered[u.ID] = true
+		if p.FindModel(u.ID) != nil {
+			result.Existing = append(result.Existing, u.ID)
+			continue
+		}
+		if m, ok := inferProviderModel(t.APIStyle(), u); ok {
+			result.New = append(result.New, m)
+		} else {
+			result.Unsupported = append(result.Unsupported, u.ID)
+		}
+	}
+	for _, m := range p.Models {
+		if !offered[m.ModelID] {
+			result.Missing = append(result.Missing, m.ModelID)
+		}
+	}
+	return result, nil
+}
+
+// listUpstreamModels 按 API 风格调用上游的模型列表接口，自动翻页，每页通过 doUpstream 使用 t.Keys 发送
+// OpenAI: GET /v1/models；Anthropic: GET /v1/models (after_id 翻页)；Google: GET /v1beta/models (pageToken 翻页)
+func listUpstreamModels(ctx context.Context, t *proxyTarget) ([]upstreamModel, error) {
+	var listed []upstreamModel
+	cursor := ""
+	for page := 0; page < maxModelListPages; page++ {
+		var path string
+		switch t.APIStyle() {
+		case "anthropic":
+			path = "/v1/models?limit=1000"
+			if cursor != "" {
+				path += "&after_id=" + url.QueryEscape(cursor)
+			}
+		case "google":
+			path = "/v1beta/models?pageSize=1000"
+			if cursor != "" {
+				path += "&pageToken=" + url.QueryEscape(cursor)
+			}
+		default:
+			path = "/v1/models"
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "GET", upstreamURL(t, path), nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := doUpstream(t, req)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= http.StatusBadRequest {
+			return nil, fmt.Errorf("upstream returned %d: %s", resp.StatusCode, upstreamErrorMessage(body))
+		}
+
+		var items []upstreamModel
+		items, cursor, err = parseModelList(t.APIStyle(), body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid model list: %w", err)
+		}
+		listed = append(listed, items...)
+		if cursor == "" {
+			return listed, nil
+		}
+	}
+	return listed, nil
+}
+
+// parseModelList 解析一页模型列表，返回下一页的游标，没有下一页时为空
+func parseModelList(apiStyle string, body []byte) ([]upstreamModel, string, error) {
+	switch apiStyle {
+	case "anthropic":
+		var page struct {
+			Data []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"display_name"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, "", err
+		}
+		items := make([]upstreamModel, 0, len(page.Data))
+		for _, m := range page.Data {
+			items = append(items, upstreamModel{ID: m.ID, DisplayName: m.DisplayName})
+		}
+		if !page.HasMore {
+			return items, "", nil
+		}
+		return items, page.LastID, nil
+
+	case "google":
+		var page struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				DisplayName                string   `json:"displayName"`
+				InputTokenLimit            int      `json:"inputTokenLimit"`
+				OutputTokenLimit           int      `json:"outputTokenLimit"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+				Thinking                   bool     `json:"thinking"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, "", err
+		}
+		items := make([]upstreamModel, 0, len(page.Models))
+		for _, m := range page.Models {
+			items = append(items, upstreamModel{
+				ID:            strings.TrimPrefix(m.Name, "models/"),
+				DisplayName:   m.DisplayName,
+				Methods:       m.SupportedGenerationMethods,
+				ContextWindow: m.InputTokenLimit,
+				MaxOutput:     m.OutputTokenLimit,
+				Thinking:      m.Thinking,
+			})
+		}
+		return items, page.NextPageToken, nil
+
+	default:
+		// OpenAI 兼容服务 (例如 OpenRouter) 可能额外返回 context_length
+		var page struct {
+			Data []struct {
+				ID            string `json:"id"`
+				ContextLength int    `json:"context_length"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, "", err
+		}
+		items := make([]upstreamModel, 0, len(page.Data))
+		for _, m := range page.Data {
+			items = append(items, upstreamModel{ID: m.ID, ContextWindow: m.ContextLength})
+		}
+		return items, "", nil
+	}
+}
+
+// unsupportedModelKeywords 代理不支持的模型 (语音、审核、旧版 completions 等) 的 ID 关键字
+var unsupportedModelKeywords = []string{
+	"whisper", "tts", "transcribe", "audio", "realtime", "moderation", "davinci", "babbage", "sora",
+}
+
+// 按模型 ID 推断能力的规则，匹配前缀或包含关键字
+var (
+	visionModelPrefixes = []string{
+		"gpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-4-vision", "gpt-5", "o1", "o3", "o4",
+		"claude-3", "claude-sonnet", "claude-opus", "claude-haiku", "gemini",
+	}
+	visionModelKeywords = []string{"vision", "-vl", "vl-", "pixtral", "llava"}
+
+	toolModelPrefixes = []string{
+		"gpt-3.5-turbo", "gpt-4", "gpt-5", "o1", "o3", "o4",
+		"claude-3", "claude-sonnet", "claude-opus", "claude-haiku", "gemini",
+	}
+
+	reasoningModelPrefixes = []string{
+		"o1", "o3", "o4", "gpt-5", "claude-3-7", "claude-sonnet-4", "claude-opus-4", "claude-haiku-4", "gemini-2.5",
+	}
+	reasoningModelKeywords = []string{"thinking", "reasoner", "reasoning", "-r1", "qwq"}
+
+	// 不支持图片或工具的同系列模型
+	textOnlyModelPrefixes = []string{"o1-mini", "o3-mini"}
+	noToolModelPrefixes   = []string{"o1-mini", "o1-preview"}
+)
+
+// inferProviderModel 根据上游返回的模型信息推断 Type 与能力，代理不支持的模型返回 false
+// 按模型 ID 推断的能力并不完整，保存在 SuggestedCapabilities 中供管理员确认；
+// Capabilities 保持为空 (能力未知，不检查图片与工具)，避免误拒绝支持工具或图片的模型
+func inferProviderModel(apiStyle string, u upstreamModel) (models.ProviderModel, bool) {
+	id := strings.ToLower(u.ID)
+	m := models.ProviderModel{
+		ModelID:       u.ID,
+		ContextWindow: u.ContextWindow,
+		MaxOutput:     u.MaxOutput,
+	}
+	if u.DisplayName != "" && u.DisplayName != u.ID {
+		m.Nickname = u.DisplayName
+	}
+
+	switch {
+	case apiStyle == "google":
+		switch {
+		case containsString(u.Methods, "generateContent"):
+			m.Type = "chat"
+		case containsString(u.Methods, "embedContent"):
+			m.Type = "embedding"
+		default:
+			return m, false
+		}
+	case strings.Contains(id, "rerank"):
+		m.Type = "rerank"
+	case strings.Contains(id, "embed"):
+		m.Type = "embedding"
+	case matchModelID(id, nil, unsupportedModelKeywords):
+		return m, false
+	default:
+		m.Type = "chat"
+	}
+	if m.Type != "chat" {
+		return m, true
+	}
+
+	if matchModelID(id, visionModelPrefixes, visionModelKeywords) && !matchModelID(id, textOnlyModelPrefixes, nil) {
+		m.SuggestedCapabilities = append(m.SuggestedCapabilities, "vision")
+	}
+	if matchModelID(id, reasoningModelPrefixes, reasoningModelKeywords) || u.Thinking {
+		m.SuggestedCapabilities = append(m.SuggestedCapabilities, "reasoning")
+	}
+	if matchModelID(id, toolModelPrefixes, nil) && !matchModelID(id, noToolModelPrefixes, nil) {
+		m.SuggestedCapabilities = append(m.SuggestedCapabilities, "tool_use")
+	}
+	if strings.Contains(id, "search") {
+		m.SuggestedCapabilities = append(m.SuggestedCapabilities, "web_search")
+	}
+	return m, true
+}
+
+// matchModelID 模型 ID 是否以 prefixes 之一开头或包含 keywords 之一
+// 前缀同时匹配带有组织前缀的 ID (例如 openrouter 的 openai/gpt-4o)
+func matchModelID(id string, prefixes, keywords []string) bool {
+	name := id[strings.LastIndex(id, "/")+1:]
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	for _, k := range keywords {
+		if strings.Contains(id, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
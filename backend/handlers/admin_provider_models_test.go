@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"testing"
+
+	"chatbox-backend/models"
+)
+
+func TestImportedModelAcceptsToolsAndImages(t *testing.T) {
+	tests := []struct {
+		name     string
+		apiStyle string
+		model    upstreamModel
+	}{
+		{"vision model", "openai", upstreamModel{ID: "qwen-vl-max"}},
+		{"reasoning model", "openai", upstreamModel{ID: "deepseek-reasoner"}},
+		{"unknown model", "openai", upstreamModel{ID: "my-custom-model"}},
+		{"gemini thinking model", "google", upstreamModel{ID: "gemini-2.5-pro", Methods: []string{"generateContent"}, Thinking: true}},
+	}
+	features := requestFeatures{Images: 1, Tools: true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := inferProviderModel(tt.apiStyle, tt.model)
+			if !ok {
+				t.Fatalf("inferProviderModel(%q) rejected the model", tt.model.ID)
+			}
+			if len(m.Capabilities) != 0 {
+				t.Errorf("Capabilities = %v, want none (guesses belong in SuggestedCapabilities)", m.Capabilities)
+			}
+			target := &proxyTarget{Provider: &models.Provider{ProviderID: "test"}, Model: &m, ModelID: m.ModelID}
+			if msg := target.checkRequest(features); msg != "" {
+				t.Errorf("checkRequest() = %q, want imported model to accept tools and images", msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SaveQuotaRequest struct {
+	Scope      string   `json:"scope" binding:"required"`  // user | group
+	Target     string   `json:"target" binding:"required"` // 用户 ID 或分组名
+	Period     string   `json:"period" binding:"required"` // day | month
+	TokenLimit *int64   `json:"tokenLimit"`                // null 表示不限制
+	CostLimit  *float64 `json:"costLimit"`                 // 按模型价格计算，未配置价格的模型费用为 0，不受限制
+}
+
+// AdminGetQuotas 获取所有配额 (管理员)
+func AdminGetQuotas(c *gin.Context) {
+	quotas, err := models.GetAllQuotas()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get quotas"})
+		return
+	}
+	if quotas == nil {
+		quotas = []models.Quota{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quotas": quotas})
+}
+
+// AdminSaveQuota 创建或更新用户 / 分组的配额 (管理员)
+func AdminSaveQuota(c *gin.Context) {
+	var req SaveQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	switch req.Scope {
+	case models.QuotaScopeGroup:
+	case models.QuotaScopeUser:
+		userID, err := strconv.ParseInt(req.Target, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if _, err := models.GetUserByID(userID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be user or group"})
+		return
+	}
+
+	if req.Period != models.QuotaPeriodDay && req.Period != models.QuotaPeriodMonth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day or month"})
+		return
+	}
+	if req.TokenLimit == nil && req.CostLimit == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "tokenLimit or costLimit is required"})
+		return
+	}
+	if (req.TokenLimit != nil && *req.TokenLimit < 0) || (req.CostLimit != nil && *req.CostLimit < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quota limits must not be negative"})
+		return
+	}
+
+	saved, err := models.SaveQuota(&models.Quota{
+		Scope:      req.Scope,
+		Target:     req.Target,
+		Period:     req.Period,
+		TokenLimit: req.TokenLimit,
+		CostLimit:  req.CostLimit,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// AdminDeleteQuota 删除配额 (管理员)
+func AdminDeleteQuota(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quota ID"})
+		return
+	}
+
+	if err := models.DeleteQuota(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete quota"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Quota deleted"})
+}
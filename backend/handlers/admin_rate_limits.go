@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SaveRateLimitRequest struct {
+	Scope             string `json:"scope" binding:"required"`  // role | user
+	Target            string `json:"target" binding:"required"` // 角色名或用户 ID
+	RequestsPerMinute *int   `json:"requestsPerMinute"`         // null 沿用上一级配置，0 不限制
+	TokensPerMinute   *int   `json:"tokensPerMinute"`
+}
+
+// AdminGetRateLimits 获取角色与用户的限流配置 (管理员)
+func AdminGetRateLimits(c *gin.Context) {
+	limits, err := models.GetAllRateLimits()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get rate limits"})
+		return
+	}
+	if limits == nil {
+		limits = []models.RateLimit{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rateLimits": limits})
+}
+
+// AdminSaveRateLimit 创建或更新角色 / 用户的限流配置 (管理员)
+func AdminSaveRateLimit(c *gin.Context) {
+	var req SaveRateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	switch req.Scope {
+	case models.RateLimitScopeRole:
+	case models.RateLimitScopeUser:
+		userID, err := strconv.ParseInt(req.Target, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		if _, err := models.GetUserByID(userID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be role or user"})
+		return
+	}
+
+	if (req.RequestsPerMinute != nil && *req.RequestsPerMinute < 0) || (req.TokensPerMinute != nil && *req.TokensPerMinute < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Rate limits must not be negative"})
+		return
+	}
+
+	saved, err := models.SaveRateLimit(&models.RateLimit{
+		Scope:             req.Scope,
+		Target:            req.Target,
+		RequestsPerMinute: req.RequestsPerMinute,
+		TokensPerMinute:   req.TokensPerMinute,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save rate limit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// AdminDeleteRateLimit 删除限流配置，恢复为上一级配置 (管理员)
+func AdminDeleteRateLimit(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rate limit ID"})
+		return
+	}
+
+	if err := models.DeleteRateLimit(id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rate limit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rate limit deleted"})
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageDateLayout 用量查询参数中的日期格式
+const usageDateLayout = "2006-01-02"
+
+// maxUsageLimit 用量汇总最多返回的条数
+const maxUsageLimit = 1000
+
+// parseUsageFilter 解析用量查询的筛选参数，返回 false 时已写入错误响应
+// from / to 为 YYYY-MM-DD (按服务器时区，to 当天包含在内)，未指定时默认为本月；
+// 其余参数: userId, group, provider, model, keySource (system | user), status (success | error | 状态码)
+func parseUsageFilter(c *gin.Context) (models.UsageFilter, bool) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 1, 0)
+
+	if s := c.Query("from"); s != "" {
+		t, err := time.ParseInLocation(usageDateLayout, s, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return models.UsageFilter{}, false
+		}
+		from = t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.ParseInLocation(usageDateLayout, s, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return models.UsageFilter{}, false
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be earlier than from"})
+		return models.UsageFilter{}, false
+	}
+
+	filter := models.UsageFilter{
+		From:      from,
+		To:        to,
+		Group:     c.Query("group"),
+		Provider:  c.Query("provider"),
+		Model:     c.Query("model"),
+		KeySource: c.Query("keySource"),
+		Status:    c.Query("status"),
+	}
+	if s := c.Query("userId"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return models.UsageFilter{}, false
+		}
+		filter.UserID = id
+	}
+	if filter.Status != "" && filter.Status != "success" && filter.Status != "error" {
+		if _, err := strconv.Atoi(filter.Status); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be success, error or a status code"})
+			return models.UsageFilter{}, false
+		}
+	}
+
+	return filter, true
+}
+
+// parseUsageQuery 解析 groupBy / sort / limit 与筛选参数，返回 false 时已写入错误响应
+func parseUsageQuery(c *gin.Context, defaultGroupBy, defaultSort string, defaultLimit int) (models.UsageQuery, bool) {
+	filter, ok := parseUsageFilter(c)
+	if !ok {
+		return models.UsageQuery{}, false
+	}
+
+	q := models.UsageQuery{
+		GroupBy: c.DefaultQuery("groupBy", defaultGroupBy),
+		Filter:  filter,
+		Sort:    c.DefaultQuery("sort", defaultSort),
+		Limit:   defaultLimit,
+	}
+	if !models.IsValidUsageGroupBy(q.GroupBy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be one of user, group, provider, model, day, hour, keySource"})
+		return q, false
+	}
+	if !models.IsValidUsageSort(q.Sort) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be one of cost, tokens, requests, errors"})
+		return q, false
+	}
+	if s := c.Query("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return q, false
+		}
+		q.Limit = limit
+	}
+	if q.Limit == 0 || q.Limit > maxUsageLimit {
+		q.Limit = maxUsageLimit
+	}
+
+	return q, true
+}
+
+// writeUsageAggregates 查询汇总数据并连同合计一起返回，合计包含 limit 之外的数据
+func writeUsageAggregates(c *gin.Context, q models.UsageQuery) {
+	items, err := models.AggregateUsage(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+	total, err := models.TotalUsage(q.Filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    q.Filter.From.Format(usageDateLayout),
+		"to":      q.Filter.To.AddDate(0, 0, -1).Format(usageDateLayout),
+		"groupBy": q.GroupBy,
+		"items":   items,
+		"total":   total,
+	})
+}
+
+// AdminGetUsage 按用户、分组、Provider、模型、天或小时汇总用量 (管理员)
+// 查询参数: groupBy (默认 day)、sort、limit 与 parseUsageFilter 支持的筛选参数
+func AdminGetUsage(c *gin.Context) {
+	q, ok := parseUsageQuery(c, "day", "cost", 0)
+	if !ok {
+		return
+	}
+	writeUsageAggregates(c, q)
+}
+
+// AdminGetTopUsage 用量最高的前 N 项 (管理员)
+// 查询参数: groupBy (默认 user)、sort (默认 tokens)、limit (默认 10)
+func AdminGetTopUsage(c *gin.Context) {
+	q, ok := parseUsageQuery(c, "user", "tokens", 10)
+	if !ok {
+		return
+	}
+	if q.GroupBy == "day" || q.GroupBy == "hour" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be one of user, group, provider, model"})
+		return
+	}
+	writeUsageAggregates(c, q)
+}
+
+// UsageErrorBreakdown 某个维度的错误率与各错误状态码的请求数
+type UsageErrorBreakdown struct {
+	Key         string           `json:"key"`
+	Label       string           `json:"label,omitempty"`
+	Requests    int64            `json:"requests"`
+	Errors      int64            `json:"errors"`
+	ErrorRate   float64          `json:"errorRate"`
+	StatusCodes map[string]int64 `json:"statusCodes"`
+}
+
+// groupStatusCounts 把错误状态码统计整理为 维度 -> 状态码 -> 请求数
+func groupStatusCounts(counts []models.UsageStatusCount) map[string]map[string]int64 {
+	byKey := make(map[string]map[string]int64)
+	for _, sc := range counts {
+		if byKey[sc.Key] == nil {
+			byKey[sc.Key] = make(map[string]int64)
+		}
+		byKey[sc.Key][strconv.Itoa(sc.StatusCode)] = sc.Count
+	}
+	return byKey
+}
+
+// AdminGetUsageErrors 按维度统计错误率与错误状态码分布，错误率高的排在前面 (管理员)
+// 查询参数: groupBy (默认 provider)、sort (默认 errors) 与 parseUsageFilter 支持的筛选参数
+func AdminGetUsageErrors(c *gin.Context) {
+	q, ok := parseUsageQuery(c, "provider", "errors", 0)
+	if !ok {
+		return
+	}
+	items, err := models.AggregateUsage(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+	counts, err := models.CountUsageErrors(q.GroupBy, q.Filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	byKey := groupStatusCounts(counts)
+
+	result := make([]UsageErrorBreakdown, 0, len(items))
+	for _, item := range items {
+		codes := byKey[item.Key]
+		if codes == nil {
+			codes = map[string]int64{}
+		}
+		result = append(result, UsageErrorBreakdown{
+			Key:         item.Key,
+			Label:       item.Label,
+			Requests:    item.Requests,
+			Errors:      item.Errors,
+			ErrorRate:   item.ErrorRate,
+			StatusCodes: codes,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    q.Filter.From.Format(usageDateLayout),
+		"to":      q.Filter.To.AddDate(0, 0, -1).Format(usageDateLayout),
+		"groupBy": q.GroupBy,
+		"items":   result,
+	})
+}
+
+// AdminGetUsageCosts 按分组 (部门)、用户、Provider 或模型汇总用量与费用 (管理员)
+// 查询参数: groupBy (默认 group) 与 parseUsageFilter 支持的筛选参数
+func AdminGetUsageCosts(c *gin.Context) {
+	q, ok := parseUsageQuery(c, "group", "cost", 0)
+	if !ok {
+		return
+	}
+	writeUsageAggregates(c, q)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageExportFlushRows 导出时每写入多少行向客户端刷新一次
+const usageExportFlushRows = 500
+
+var usageRecordCSVHeader = []string{
+	"id", "created_at", "user_id", "username", "group", "provider", "model", "endpoint", "key_source",
+	"prompt_tokens", "completion_tokens", "cached_tokens", "total_tokens", "image_count",
+	"cost", "latency_ms", "status_code", "error_message", "stream",
+}
+
+var usageAggregateCSVHeader = []string{
+	"key", "label", "requests", "errors", "error_rate", "prompt_tokens", "completion_tokens",
+	"cached_tokens", "total_tokens", "image_count", "cost", "avg_latency_ms",
+}
+
+// usageExporter 按 CSV 或 JSONL 格式逐行写出导出数据
+type usageExporter struct {
+	c    *gin.Context
+	csv  *csv.Writer   // format=csv
+	buf  *bufio.Writer // format=jsonl
+	enc  *json.Encoder // format=jsonl
+	rows int
+}
+
+func newUsageExporter(c *gin.Context, format, filename string, header []string) *usageExporter {
+	e := &usageExporter{c: c}
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		e.csv = csv.NewWriter(c.Writer)
+		e.csv.Write(header)
+	} else {
+		c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+		e.buf = bufio.NewWriter(c.Writer)
+		e.enc = json.NewEncoder(e.buf)
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.`+format+`"`)
+	c.Status(http.StatusOK)
+	return e
+}
+
+// write 写出一行，csv 格式使用 fields，jsonl 格式序列化 v
+func (e *usageExporter) write(fields []string, v interface{}) error {
+	var err error
+	if e.csv != nil {
+		err = e.csv.Write(fields)
+	} else {
+		err = e.enc.Encode(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%usageExportFlushRows == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *usageExporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	} else if err := e.buf.Flush(); err != nil {
+		return err
+	}
+	e.c.Writer.Flush()
+	return nil
+}
+
+// fail 导出出错时调用：尚未向客户端写出数据时返回 500 (缓冲区中未写出的数据被丢弃)，
+// 否则直接关闭连接，让客户端收到不完整的响应而不是看起来完整但被截断的文件
+func (e *usageExporter) fail(err error) {
+	log.Printf("[Usage] export failed after %d rows: %v", e.rows, err)
+	if !e.c.Writer.Written() {
+		e.c.Writer.Header().Del("Content-Disposition")
+		e.c.Writer.Header().Del("Content-Type")
+		e.c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export usage"})
+		return
+	}
+
+	// HTTP/2 不支持 Hijack，只能结束响应
+	conn, _, herr := e.c.Writer.Hijack()
+	if herr != nil {
+		log.Printf("[Usage] failed to abort export connection: %v", herr)
+		return
+	}
+	conn.Close()
+}
+
+// AdminExportUsage 导出用量明细或汇总 (管理员)
+// 查询参数: format (csv | jsonl，默认 csv)、type (records | aggregate，默认 records)、
+// aggregate 时的 groupBy (默认 user) 与 sort，以及 parseUsageFilter 支持的筛选参数
+// 明细数据边查询边写出，不会一次性加载到内存中
+func AdminExportUsage(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or jsonl"})
+		return
+	}
+
+	switch c.DefaultQuery("type", "records") {
+	case "records":
+		exportUsageRecords(c, format)
+	case "aggregate":
+		exportUsageAggregates(c, format)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be records or aggregate"})
+	}
+}
+
+func exportUsageRecords(c *gin.Context, format string) {
+	filter, ok := parseUsageFilter(c)
+	if !ok {
+		return
+	}
+
+	filename := "usage-" + filter.From.Format(usageDateLayout) + "-" + filter.To.AddDate(0, 0, -1).Format(usageDateLayout)
+	e := newUsageExporter(c, format, filename, usageRecordCSVHeader)
+	err := models.EachUsageRecord(filter, func(r *models.UsageRecordDetail) error {
+		var fields []string
+		if e.csv != nil {
+			fields = []string{
+				strconv.FormatInt(r.ID, 10), r.CreatedAt.Format(time.RFC3339), strconv.FormatInt(r.UserID, 10),
+				r.Username, r.Group, r.Provider, r.Model, r.Endpoint, r.KeySource,
+				strconv.Itoa(r.PromptTokens), strconv.Itoa(r.CompletionTokens), strconv.Itoa(r.CachedTokens),
+				strconv.Itoa(r.TotalTokens), strconv.Itoa(r.ImageCount), strconv.FormatFloat(r.Cost, 'f', -1, 64),
+				strconv.FormatInt(r.LatencyMs, 10), strconv.Itoa(r.StatusCode), r.ErrorMessage, strconv.FormatBool(r.Stream),
+			}
+		}
+		return e.write(fields, r)
+	})
+	if err == nil {
+		err = e.flush()
+	}
+	if err != nil {
+		e.fail(err)
+	}
+}
+
+func exportUsageAggregates(c *gin.Context, format string) {
+	q, ok := parseUsageQuery(c, "user", "cost", 0)
+	if !ok {
+		return
+	}
+	// 导出默认不限制条数
+	if c.Query("limit") == "" {
+		q.Limit = 0
+	}
+
+	items, err := models.AggregateUsage(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export usage"})
+		return
+	}
+
+	filename := "usage-by-" + q.GroupBy + "-" + q.Filter.From.Format(usageDateLayout) + "-" + q.Filter.To.AddDate(0, 0, -1).Format(usageDateLayout)
+	e := newUsageExporter(c, format, filename, usageAggregateCSVHeader)
+	for i := range items {
+		a := &items[i]
+		var fields []string
+		if e.csv != nil {
+			fields = []string{
+				a.Key, a.Label, strconv.FormatInt(a.Requests, 10), strconv.FormatInt(a.Errors, 10),
+				strconv.FormatFloat(a.ErrorRate, 'f', 4, 64), strconv.FormatInt(a.PromptTokens, 10),
+				strconv.FormatInt(a.CompletionTokens, 10), strconv.FormatInt(a.CachedTokens, 10),
+				strconv.FormatInt(a.TotalTokens, 10), strconv.FormatInt(a.ImageCount, 10),
+				strconv.FormatFloat(a.Cost, 'f', -1, 64), strconv.FormatFloat(a.AvgLatencyMs, 'f', 1, 64),
+			}
+		}
+		if err = e.write(fields, a); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = e.flush()
+	}
+	if err != nil {
+		e.fail(err)
+	}
+}
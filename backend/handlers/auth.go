@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -28,6 +29,12 @@ type AuthResponse struct {
 	User  models.UserResponse `json:"user"`
 }
 
+// CurrentUserResponse 当前用户信息，附带生效的配额与剩余额度
+type CurrentUserResponse struct {
+	models.UserResponse
+	Quotas []models.QuotaStatus `json:"quotas"` // 配额查询失败时为 null
+}
+
 // Login 用户登录
 func Login(c *gin.Context) {
 	var req LoginRequest
@@ -63,6 +70,7 @@ func Login(c *gin.Context) {
 }
 
 // GetCurrentUser 获取当前登录用户信息
+// 与代理一样，配额查询失败时不影响使用：记录日志并省略配额信息
 func GetCurrentUser(c *gin.Context) {
 	user := middleware.GetCurrentUser(c)
 	if user == nil {
@@ -70,7 +78,16 @@ func GetCurrentUser(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, user.ToResponse())
+	quotas, err := models.GetUserQuotaStatuses(user)
+	if err != nil {
+		log.Printf("[Auth] failed to get quotas for user %d: %v", user.ID, err)
+		quotas = nil
+	}
+
+	c.JSON(http.StatusOK, CurrentUserResponse{
+		UserResponse: user.ToResponse(),
+		Quotas:       quotas,
+	})
 }
 
 // ChangePassword 修改密码
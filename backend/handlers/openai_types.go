@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI Chat Completions 协议结构，用于在 OpenAI 与其他 API 风格之间转换
+
+type openAIChatRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	TopP                *float64        `json:"top_p,omitempty"`
+	MaxTokens           *int            `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int            `json:"max_completion_tokens,omitempty"`
+	Stop                json.RawMessage `json:"stop,omitempty"`
+	Tools               []openAITool    `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage `json:"tool_choice,omitempty"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    json.RawMessage  `json:"content,omitempty"` // string 或 []openAIContentPart
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"` // text | image_url
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int   `json:"index,omitempty"` // 仅流式响应使用
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int                        `json:"prompt_tokens"`
+	CompletionTokens    int                        `json:"completion_tokens"`
+	TotalTokens         int                        `json:"total_tokens"`
+	PromptTokensDetails *openAIPromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+type openAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// cachedPromptTokens 构造缓存命中的 tokens 明细，没有命中缓存时返回 nil
+func cachedPromptTokens(n int) *openAIPromptTokensDetails {
+	if n <= 0 {
+		return nil
+	}
+	return &openAIPromptTokensDetails{CachedTokens: n}
+}
+
+type openAIResponseMessage struct {
+	Role             string           `json:"role,omitempty"`
+	Content          *string          `json:"content,omitempty"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int                    `json:"index"`
+	Message      *openAIResponseMessage `json:"message,omitempty"`
+	Delta        *openAIResponseMessage `json:"delta,omitempty"`
+	FinishReason *string                `json:"finish_reason"`
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// newChatCompletionID 生成 chatcmpl- 前缀的响应 ID
+func newChatCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+// newOpenAIChunk 构造一个流式响应块
+func newOpenAIChunk(id, model string, created int64) *openAIChatResponse {
+	return &openAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   model,
+		Choices: []openAIChoice{},
+	}
+}
+
+// newOpenAICompletion 构造一个非流式响应
+func newOpenAICompletion(id, model string) *openAIChatResponse {
+	if id == "" {
+		id = newChatCompletionID()
+	}
+	return &openAIChatResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   model,
+	}
+}
+
+// contentParts 将 OpenAI 的 content 字段 (字符串或分段数组) 解析为分段列表
+func (m *openAIMessage) contentParts() []openAIContentPart {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return nil
+	}
+
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		if text == "" {
+			return nil
+		}
+		return []openAIContentPart{{Type: "text", Text: text}}
+	}
+
+	var parts []openAIContentPart
+	json.Unmarshal(m.Content, &parts)
+	return parts
+}
+
+// textContent 拼接消息中的全部文本分段
+func (m *openAIMessage) textContent() string {
+	var sb strings.Builder
+	for _, part := range m.contentParts() {
+		if part.Type == "text" {
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(part.Text)
+		}
+	}
+	return sb.String()
+}
+
+// stopSequences 解析 stop 字段 (字符串或字符串数组)
+func (r *openAIChatRequest) stopSequences() []string {
+	if len(r.Stop) == 0 {
+		return nil
+	}
+	var single string
+	if err := json.Unmarshal(r.Stop, &single); err == nil {
+		if single == "" {
+			return nil
+		}
+		return []string{single}
+	}
+	var list []string
+	json.Unmarshal(r.Stop, &list)
+	return list
+}
+
+// maxOutputTokens 返回请求中的最大输出 tokens，max_completion_tokens 优先
+func (r *openAIChatRequest) maxOutputTokens() int {
+	if r.MaxCompletionTokens != nil {
+		return *r.MaxCompletionTokens
+	}
+	if r.MaxTokens != nil {
+		return *r.MaxTokens
+	}
+	return 0
+}
+
+// includeUsage 流式请求是否要求在最后返回 usage
+func (r *openAIChatRequest) includeUsage() bool {
+	return r.StreamOptions != nil && r.StreamOptions.IncludeUsage
+}
+
+// toolChoice 解析 tool_choice，返回模式 (auto | none | required | function) 与指定的函数名
+func (r *openAIChatRequest) toolChoice() (string, string) {
+	if len(r.ToolChoice) == 0 {
+		return "", ""
+	}
+	var mode string
+	if err := json.Unmarshal(r.ToolChoice, &mode); err == nil {
+		return mode, ""
+	}
+	var choice struct {
+		Type     string `json:"type"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	json.Unmarshal(r.ToolChoice, &choice)
+	return "function", choice.Function.Name
+}
+
+// parseDataURL 解析 data:<mime>;base64,<data> 格式的图片地址
+func parseDataURL(u string) (mimeType, data string, ok bool) {
+	if !strings.HasPrefix(u, "data:") {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(strings.TrimPrefix(u, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
+
+// openAIError 以 OpenAI 的格式写入错误响应，用于协议转换后的上游错误
+func openAIError(c *gin.Context, status int, message, errType string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"code":    status,
+		},
+	})
+}
+
+// openAIRequestError 以 OpenAI 的格式写入代理自身的错误 (请求错误、配置错误等)，可作为 proxyErrorFunc 使用
+func openAIRequestError(c *gin.Context, status int, message string) {
+	errType := "invalid_request_error"
+	if status >= http.StatusInternalServerError {
+		errType = "api_error"
+	}
+	openAIError(c, status, message, errType)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
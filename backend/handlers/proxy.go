@@ -2,171 +2,395 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"chatbox-backend/middleware"
 	"chatbox-backend/models"
+	"chatbox-backend/secret"
+	"chatbox-backend/upstream"
 
 	"github.com/gin-gonic/gin"
 )
 
-// getEnterAIProvider 获取 EnterAI 配置
-func getEnterAIProvider() (*models.Provider, error) {
-	providers, err := models.GetEnabledProviders()
+// proxyErrorFunc 按调用方使用的协议格式写入错误响应
+type proxyErrorFunc func(c *gin.Context, status int, message string)
+
+// jsonError 默认错误格式 {"error": "..."}
+func jsonError(c *gin.Context, status int, message string) {
+	c.JSON(status, gin.H{"error": message})
+}
+
+// prepareProxyRequest 读取请求体并根据 model 字段解析目标 Provider
+// apiStyle 不为空时只匹配该风格的模型，返回 false 时已写入错误响应
+func prepareProxyRequest(c *gin.Context, apiStyle string, writeErr proxyErrorFunc) (map[string]interface{}, *proxyTarget, bool) {
+	// 读取请求体
+	body, err := io.ReadAll(c.Request.Body)
 	if err != nil {
-		return nil, err
+		writeErr(c, http.StatusBadRequest, "Failed to read request body")
+		return nil, nil, false
 	}
 
-	for _, p := range providers {
-		if p.ProviderID == "enter-ai" {
-			return &p, nil
-		}
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(body, &requestData); err != nil {
+		writeErr(c, http.StatusBadRequest, "Invalid request body")
+		return nil, nil, false
 	}
 
-	return nil, nil
+	model, _ := requestData["model"].(string)
+	if model == "" {
+		writeErr(c, http.StatusBadRequest, "model is required")
+		return nil, nil, false
+	}
+
+	target, ok := resolveTargetOrError(c, model, apiStyle, writeErr)
+	if !ok {
+		return nil, nil, false
+	}
+
+	// 去掉 provider 前缀后再转发给上游
+	requestData["model"] = target.ModelID
+	return requestData, target, true
 }
 
-// ProxyChatCompletion 代理聊天完成请求
-// 用于非管理员用户使用系统配置的 EnterAI
-func ProxyChatCompletion(c *gin.Context) {
-	// 获取 EnterAI 配置
-	providers, err := models.GetEnabledProviders()
+// resolveTargetOrError 解析目标 Provider 并加载系统 Key 与当前用户的个人 Key，返回 false 时已写入错误响应
+// 用户的配额用尽时只使用个人 Key，没有个人 Key 时返回 429
+func resolveTargetOrError(c *gin.Context, model, apiStyle string, writeErr proxyErrorFunc) (*proxyTarget, bool) {
+	var userID int64
+	user := middleware.GetCurrentUser(c)
+	if user != nil {
+		userID = user.ID
+	}
+
+	pc, err := loadProviderCatalog(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get provider configuration"})
-		return
+		writeErr(c, http.StatusInternalServerError, "Failed to get provider configuration")
+		return nil, false
+	}
+	// 配额只限制系统 Key 的用量，用尽后只能使用个人 Key
+	quota := middleware.ExceededQuota(user)
+	if quota != nil {
+		pc.personalKeysOnly()
 	}
 
-	// 查找 EnterAI provider
-	var enterAI *models.Provider
-	for _, p := range providers {
-		if p.ProviderID == "enter-ai" {
-			enterAI = &p
-			break
+	target, err := pc.resolve(model, apiStyle)
+	if err != nil {
+		writeErr(c, http.StatusNotFound, "Model not available: "+model)
+		return nil, false
+	}
+	if len(target.Keys) == 0 && quota != nil {
+		writeErr(c, http.StatusTooManyRequests, middleware.QuotaExceededMessage(c, quota))
+		return nil, false
+	}
+	if len(target.Keys) == 0 {
+		if target.Provider.AllowCustomKey {
+			writeErr(c, http.StatusBadRequest, target.Provider.Name+" API key not configured, save a personal key to use this provider")
+		} else {
+			writeErr(c, http.StatusBadRequest, target.Provider.Name+" API key not configured")
 		}
+		return nil, false
 	}
 
-	if enterAI == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "EnterAI provider not configured"})
-		return
-	}
+	return target, true
+}
 
-	if enterAI.APIKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "EnterAI API key not configured"})
-		return
+// newUpstreamRequest 创建发往上游的 POST 请求，认证请求头由 doUpstream 在发送时按选中的 Key 设置
+// ctx 使用客户端请求的 context，客户端断开时上游请求随之取消
+func newUpstreamRequest(ctx context.Context, targetURL string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", targetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
 
-	// 读取请求体
-	body, err := io.ReadAll(c.Request.Body)
+// maxKeyAttempts 单个请求最多尝试的 Key 数量
+const maxKeyAttempts = 3
+
+// doUpstream 使用目标 Provider 对应的共享客户端发送请求
+// 个人 Key 最先尝试，系统 Key 按加权轮询排在后面，遇到网络错误或 401/403/429/5xx 时让该 Key 暂时退出轮询并换下一个 Key 重试
+// 熔断只针对系统 Key：轮到系统 Key 时 Provider 处于熔断状态则返回 upstream.ErrCircuitOpen，
+// 个人 Key 不受熔断影响，也不占用半开状态的探测名额；只有使用系统 Key 的调用结果计入健康统计
+func doUpstream(target *proxyTarget, req *http.Request) (*http.Response, error) {
+	client, err := upstream.Client(target.Provider.ProxyURL)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
-		return
+		return nil, err
 	}
 
-	// 构建目标 URL
-	apiHost := enterAI.APIHost
-	if apiHost == "" {
-		apiHost = "https://api.openai.com"
+	providerID := target.Provider.ID
+
+	var keys, systemKeys []upstream.Key
+	for _, k := range target.Keys {
+		if k.Personal {
+			keys = append(keys, k)
+		} else {
+			systemKeys = append(systemKeys, k)
+		}
+	}
+	keys = append(keys, upstream.OrderKeys(providerID, systemKeys)...)
+	if len(keys) > maxKeyAttempts {
+		keys = keys[:maxKeyAttempts]
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no API key available")
 	}
-	// 确保 apiHost 没有尾部斜杠
-	apiHost = strings.TrimSuffix(apiHost, "/")
-	
-	// 构建完整的 API URL
-	targetURL := apiHost + "/v1/chat/completions"
 
-	// 创建代理请求
-	proxyReq, err := http.NewRequest("POST", targetURL, bytes.NewReader(body))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy request"})
-		return
+	for i, key := range keys {
+		attempt := req
+		if i > 0 {
+			// 重试时需要重新生成请求体
+			attempt = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attempt.Body = body
+			}
+		}
+		last := i == len(keys)-1
+
+		// Key 加密保存，只在发送请求前解密
+		apiKey, err := secret.Reveal(key.Value)
+		if err != nil {
+			log.Printf("[Upstream] provider=%s key=%d failed to decrypt: %v", target.Provider.ProviderID, key.ID, err)
+			if last {
+				return nil, err
+			}
+			continue
+		}
+
+		// 熔断只检查系统 Key (半开状态下放行的探测请求必须记录结果)，前一次失败可能已触发熔断
+		if !key.Personal && !upstream.Allow(providerID) {
+			return nil, &circuitOpenError{provider: target.Provider.ProviderID, retryAfter: upstream.RetryAfter(providerID)}
+		}
+		setUpstreamAuth(attempt, target, apiKey)
+
+		start := time.Now()
+		resp, err := client.Do(attempt)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			// 个人 Key 的失败不影响 Provider 的熔断与系统 Key 的冷却
+			if !key.Personal {
+				upstream.Record(providerID, key.ID, 0, time.Since(start), err)
+				upstream.MarkKeyFailure(providerID, key.ID, 0, "")
+			}
+			log.Printf("[Upstream] provider=%s key=%d request failed: %v", target.Provider.ProviderID, key.ID, err)
+			if last {
+				return nil, err
+			}
+			continue
+		}
+
+		if !key.Personal {
+			upstream.Record(providerID, key.ID, resp.StatusCode, time.Since(start), nil)
+		}
+		target.KeySource = models.KeySourceSystem
+		if key.Personal {
+			target.KeySource = models.KeySourceUser
+		}
+
+		if upstream.ShouldFailover(resp.StatusCode) {
+			if !key.Personal {
+				upstream.MarkKeyFailure(providerID, key.ID, resp.StatusCode, resp.Header.Get("Retry-After"))
+			}
+			log.Printf("[Upstream] provider=%s key=%d returned %d", target.Provider.ProviderID, key.ID, resp.StatusCode)
+			if !last {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+				resp.Body.Close()
+				continue
+			}
+			return resp, nil
+		}
+
+		if !key.Personal {
+			upstream.MarkKeySuccess(providerID, key.ID)
+		}
+		return resp, nil
 	}
 
-	// 设置请求头
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyReq.Header.Set("Authorization", "Bearer "+enterAI.APIKey)
+	return nil, errors.New("no API key available")
+}
+
+// circuitOpenError Provider 熔断，请求未发送，errors.Is(err, upstream.ErrCircuitOpen) 成立
+type circuitOpenError struct {
+	provider   string
+	retryAfter time.Duration
+}
 
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to AI service: " + err.Error()})
-		return
+func (e *circuitOpenError) Error() string {
+	return upstream.ErrCircuitOpen.Error() + " for provider " + e.provider
+}
+
+func (e *circuitOpenError) Unwrap() error { return upstream.ErrCircuitOpen }
+
+// upstreamFailure 返回上游请求失败时响应的状态码与错误信息
+// 熔断时请求没有发出，返回 503 与 Retry-After，与连接失败的 502 区分
+func upstreamFailure(c *gin.Context, err error) (int, string) {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		secs := int(math.Ceil(open.retryAfter.Seconds()))
+		if secs < 1 {
+			secs = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(secs))
+		return http.StatusServiceUnavailable, "AI service temporarily unavailable: " + err.Error()
 	}
-	defer resp.Body.Close()
+	return http.StatusBadGateway, "Failed to connect to AI service: " + err.Error()
+}
+
+// setUpstreamAuth 按目标的 API 风格设置上游认证请求头
+func setUpstreamAuth(req *http.Request, target *proxyTarget, apiKey string) {
+	switch target.APIStyle() {
+	case "anthropic":
+		req.Header.Set("x-api-key", apiKey)
+		if req.Header.Get("anthropic-version") == "" {
+			req.Header.Set("anthropic-version", defaultAnthropicVersion)
+		}
+	case "google":
+		req.Header.Set("x-goog-api-key", apiKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+}
 
+// forwardUpstreamResponse 将上游响应原样转发给客户端，流式响应按 SSE 事件逐个透传
+// clientStyle 为客户端使用的协议，用于格式化流中断时的错误事件
+func forwardUpstreamResponse(c *gin.Context, resp *http.Response, clientStyle string) {
 	// 检查是否是流式响应
 	contentType := resp.Header.Get("Content-Type")
 	if strings.Contains(contentType, "text/event-stream") {
-		// 流式响应
-		c.Header("Content-Type", "text/event-stream")
-		c.Header("Cache-Control", "no-cache")
-		c.Header("Connection", "keep-alive")
-		c.Header("Transfer-Encoding", "chunked")
-		
-		c.Stream(func(w io.Writer) bool {
-			buf := make([]byte, 1024)
-			n, err := resp.Body.Read(buf)
-			if err != nil {
-				return false
-			}
-			w.Write(buf[:n])
-			return true
-		})
-	} else {
-		// 非流式响应
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
-			return
-		}
+		streamUpstreamEvents(c, resp, clientStyle)
+		return
+	}
 
-		// 转发响应
-		c.Data(resp.StatusCode, contentType, respBody)
+	// 非流式响应
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
+		return
 	}
+	if usage := usageFromContext(c); usage != nil && resp.StatusCode < http.StatusBadRequest {
+		usage.observe(respBody)
+	}
+
+	// 转发响应
+	c.Data(resp.StatusCode, contentType, respBody)
 }
 
-// ProxyImageGeneration 代理图片生成请求
-// 用于非管理员用户使用系统配置的 EnterAI 生成图片
-func ProxyImageGeneration(c *gin.Context) {
-	enterAI, err := getEnterAIProvider()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get provider configuration"})
+// ProxyChatCompletion 代理聊天完成请求
+// 根据请求中的 model 转发到对应的系统 Provider
+func ProxyChatCompletion(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 		return
 	}
 
-	if enterAI == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "EnterAI provider not configured"})
+	requestData, target, ok := prepareProxyRequest(c, "", jsonError)
+	if !ok {
 		return
 	}
+	provider := target.Provider
 
-	if enterAI.APIKey == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "EnterAI API key not configured"})
+	if target.ModelType() != "chat" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Model " + target.ModelID + " is not a chat model"})
 		return
 	}
 
-	// 读取请求体
-	body, err := io.ReadAll(c.Request.Body)
+	// anthropic / google 风格的模型需要转换协议，提前解析请求以便在备用模型之间复用
+	chatReq, err := parseChatRequest(requestData)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat completion request: " + err.Error()})
+		return
+	}
+
+	// 检查模型能力与上下文窗口，最大输出 tokens 超出模型限制时在转发前截断
+	features := parseRequestFeatures("openai", requestData)
+	if msg := target.checkRequest(features); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if msg := target.checkTranslation(chatReq); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	log.Printf("[ChatProxy] user=%d(%s) provider=%s model=%s style=%s", user.ID, user.Username, provider.ProviderID, target.ModelID, target.APIStyle())
+
+	usage := startUsage(c, user, target, "chat", chatReq.Stream)
+	defer usage.finish(c)
+
+	// 客户端没有要求返回 usage 时，为统计用量向 OpenAI 风格的上游注入 include_usage，
+	// 并在转发时去掉 usage chunk
+	if chatReq.Stream && !chatReq.includeUsage() {
+		requestData["stream_options"] = map[string]interface{}{"include_usage": true}
+		usage.stripUsageChunk = true
+	}
+
+	// 按备用链发送请求，上游 5xx 或连接失败时切换到下一个模型
+	resp, served, err := doUpstreamWithFallback(c, translatableChain(supportedChain(fallbackChain(target, ""), features), chatReq), func(t *proxyTarget) (*http.Request, error) {
+		if t.APIStyle() != "openai" {
+			return newTranslatedChatRequest(c.Request.Context(), t, chatReq)
+		}
+		data := cloneJSON(requestData)
+		data["model"] = t.ModelID
+		applyMaxTokens(data, "openai", t, features)
+		body, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		return newUpstreamRequest(c.Request.Context(), upstreamURL(t, "/v1/chat/completions"), body)
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		status, msg := upstreamFailure(c, err)
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
+	defer resp.Body.Close()
+
+	if served.APIStyle() != "openai" {
+		writeTranslatedChatResponse(c, served, chatReq, resp)
+		return
+	}
+	forwardUpstreamResponse(c, resp, "openai")
+}
 
-	// 打印原始请求体用于调试
-	log.Printf("[ImageProxy] Original request body: %s", string(body))
+// ProxyImageGeneration 代理图片生成请求
+// 根据请求中的 model 转发到对应的系统 Provider 生成图片
+func ProxyImageGeneration(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
 
 	// 解析并转换请求体格式
 	// ai-sdk 可能发送的格式与 OpenAI API 不完全兼容
-	var requestData map[string]interface{}
-	if err := json.Unmarshal(body, &requestData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	requestData, target, ok := prepareProxyRequest(c, "openai", jsonError)
+	if !ok {
 		return
 	}
+	provider := target.Provider
+
+	log.Printf("[ImageProxy] user=%d(%s) provider=%s model=%s", user.ID, user.Username, provider.ProviderID, target.ModelID)
+
+	usage := startUsage(c, user, target, "image", false)
+	defer usage.finish(c)
 
 	// 如果 prompt 是对象类型，提取文本
 	if prompt, ok := requestData["prompt"]; ok {
-		log.Printf("[ImageProxy] Prompt type: %T, value: %v", prompt, prompt)
 		switch p := prompt.(type) {
 		case map[string]interface{}:
 			// 如果是对象，尝试提取 text 字段
@@ -196,32 +420,21 @@ func ProxyImageGeneration(c *gin.Context) {
 		return
 	}
 
-	log.Printf("[ImageProxy] Converted request body: %s", string(convertedBody))
-
 	// 构建目标 URL
-	apiHost := enterAI.APIHost
-	if apiHost == "" {
-		apiHost = "https://api.openai.com"
-	}
-	apiHost = strings.TrimSuffix(apiHost, "/")
-	targetURL := apiHost + "/v1/images/generations"
+	targetURL := upstreamURL(target, "/v1/images/generations")
 
 	// 创建代理请求
-	proxyReq, err := http.NewRequest("POST", targetURL, bytes.NewReader(convertedBody))
+	proxyReq, err := newUpstreamRequest(c.Request.Context(), targetURL, convertedBody)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy request"})
 		return
 	}
 
-	// 设置请求头
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyReq.Header.Set("Authorization", "Bearer "+enterAI.APIKey)
-
 	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(proxyReq)
+	resp, err := doUpstream(target, proxyReq)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to connect to AI service: " + err.Error()})
+		status, msg := upstreamFailure(c, err)
+		c.JSON(status, gin.H{"error": msg})
 		return
 	}
 	defer resp.Body.Close()
@@ -232,6 +445,10 @@ func ProxyImageGeneration(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response"})
 		return
 	}
+	if resp.StatusCode < http.StatusBadRequest {
+		usage.observe(respBody)
+		usage.observeImages(respBody)
+	}
 
 	// 转发响应
 	contentType := resp.Header.Get("Content-Type")
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"chatbox-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAnthropicVersion 客户端未指定 anthropic-version 时使用的版本
+const defaultAnthropicVersion = "2023-06-01"
+
+// anthropicErrorTypes HTTP 状态码对应的 Anthropic 错误类型
+var anthropicErrorTypes = map[int]string{
+	http.StatusBadRequest:            "invalid_request_error",
+	http.StatusUnauthorized:          "authentication_error",
+	http.StatusForbidden:             "permission_error",
+	http.StatusNotFound:              "not_found_error",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusTooManyRequests:       "rate_limit_error",
+	529:                              "overloaded_error",
+}
+
+// anthropicError 以 Anthropic Messages API 的格式写入错误响应
+func anthropicError(c *gin.Context, status int, message string) {
+	errType, ok := anthropicErrorTypes[status]
+	if !ok {
+		errType = "api_error"
+	}
+	c.JSON(status, gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    errType,
+			"message": message,
+		},
+	})
+}
+
+// ProxyAnthropicMessages 代理 Anthropic Messages API 请求
+// 只匹配 apiStyle 为 anthropic 的模型，请求体与响应 (包括 SSE 事件) 原样透传
+func ProxyAnthropicMessages(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		anthropicError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	requestData, target, ok := prepareProxyRequest(c, "anthropic", anthropicError)
+	if !ok {
+		return
+	}
+	provider := target.Provider
+
+	// 检查模型能力与上下文窗口，max_tokens 超出模型限制时在转发前截断
+	features := parseRequestFeatures("anthropic", requestData)
+	if msg := target.checkRequest(features); msg != "" {
+		anthropicError(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	log.Printf("[AnthropicProxy] user=%d(%s) provider=%s model=%s", user.ID, user.Username, provider.ProviderID, target.ModelID)
+
+	stream, _ := requestData["stream"].(bool)
+	usage := startUsage(c, user, target, "anthropic", stream)
+	defer usage.finish(c)
+
+	// 版本与 beta 特性沿用客户端的设置
+	version := c.GetHeader("anthropic-version")
+	if version == "" {
+		version = defaultAnthropicVersion
+	}
+	beta := c.GetHeader("anthropic-beta")
+
+	// 按备用链发送请求，只使用 anthropic 风格的备用模型
+	resp, _, err := doUpstreamWithFallback(c, supportedChain(fallbackChain(target, "anthropic"), features), func(t *proxyTarget) (*http.Request, error) {
+		data := cloneJSON(requestData)
+		data["model"] = t.ModelID
+		applyMaxTokens(data, "anthropic", t, features)
+		body, err := json.Marshal(data)
+		if err != nil {
+			return nil, err
+		}
+		proxyReq, err := newUpstreamRequest(c.Request.Context(), upstreamURL(t, "/v1/messages"), body)
+		if err != nil {
+			return nil, err
+		}
+		proxyReq.Header.Set("anthropic-version", version)
+		if beta != "" {
+			proxyReq.Header.Set("anthropic-beta", beta)
+		}
+		return proxyReq, nil
+	})
+	if err != nil {
+		status, msg := upstreamFailure(c, err)
+		anthropicError(c, status, msg)
+		return
+	}
+	defer resp.Body.Close()
+
+	// 上游的 JSON 错误本身就是 Anthropic 格式，直接透传；网关返回的非 JSON 错误需要包装
+	if resp.StatusCode >= http.StatusBadRequest && !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		anthropicError(c, resp.StatusCode, "Upstream error: "+strings.TrimSpace(string(respBody)))
+		return
+	}
+
+	forwardUpstreamResponse(c, resp, "anthropic")
+}
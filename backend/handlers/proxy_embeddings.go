@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"chatbox-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiEmbeddingRequest Gemini batchEmbedContents 请求
+type geminiEmbeddingRequest struct {
+	Requests []geminiEmbedContent `json:"requests"`
+}
+
+type geminiEmbedContent struct {
+	Model                string        `json:"model"`
+	Content              geminiContent `json:"content"`
+	OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+}
+
+// embeddingInputs 解析 OpenAI embeddings 的 input 字段 (字符串或字符串数组)
+func embeddingInputs(input interface{}) []string {
+	switch v := input.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var inputs []string
+		for _, item := range v {
+			if text, ok := item.(string); ok {
+				inputs = append(inputs, text)
+			}
+		}
+		return inputs
+	}
+	return nil
+}
+
+// requireModelType 校验目标模型类型，返回 false 时已写入错误响应
+func requireModelType(c *gin.Context, target *proxyTarget, modelType string) bool {
+	if target.ModelType() != modelType {
+		openAIRequestError(c, http.StatusBadRequest, "Model "+target.ModelID+" is not a "+modelType+" model")
+		return false
+	}
+	return true
+}
+
+// ProxyEmbeddings 代理 OpenAI 格式的 embeddings 请求
+// 只接受 type 为 embedding 的模型，google 风格的模型转换为 batchEmbedContents
+func ProxyEmbeddings(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		openAIRequestError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	requestData, target, ok := prepareProxyRequest(c, "", openAIRequestError)
+	if !ok {
+		return
+	}
+	if !requireModelType(c, target, "embedding") {
+		return
+	}
+
+	log.Printf("[EmbeddingProxy] user=%d(%s) provider=%s model=%s", user.ID, user.Username, target.Provider.ProviderID, target.ModelID)
+
+	usage := startUsage(c, user, target, "embedding", false)
+	defer usage.finish(c)
+
+	switch target.APIStyle() {
+	case "openai":
+		proxyOpenAIJSON(c, target, requestData, "/v1/embeddings")
+	case "google":
+		proxyGeminiEmbeddings(c, target, requestData)
+	default:
+		openAIRequestError(c, http.StatusBadRequest, "Embeddings are not supported for API style: "+target.APIStyle())
+	}
+}
+
+// ProxyRerank 代理 rerank 请求 (Jina / Cohere 兼容格式)
+// 只接受 type 为 rerank 的模型
+func ProxyRerank(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		openAIRequestError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	requestData, target, ok := prepareProxyRequest(c, "openai", openAIRequestError)
+	if !ok {
+		return
+	}
+	if !requireModelType(c, target, "rerank") {
+		return
+	}
+
+	log.Printf("[RerankProxy] user=%d(%s) provider=%s model=%s", user.ID, user.Username, target.Provider.ProviderID, target.ModelID)
+
+	usage := startUsage(c, user, target, "rerank", false)
+	defer usage.finish(c)
+
+	proxyOpenAIJSON(c, target, requestData, "/v1/rerank")
+}
+
+// proxyOpenAIJSON 将 JSON 请求原样转发到 OpenAI 风格上游的指定路径
+func proxyOpenAIJSON(c *gin.Context, target *proxyTarget, requestData map[string]interface{}, path string) {
+	body, err := json.Marshal(requestData)
+	if err != nil {
+		openAIRequestError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	proxyReq, err := newUpstreamRequest(c.Request.Context(), upstreamURL(target, path), body)
+	if err != nil {
+		openAIRequestError(c, http.StatusInternalServerError, "Failed to create proxy request")
+		return
+	}
+
+	resp, err := doUpstream(target, proxyReq)
+	if err != nil {
+		status, msg := upstreamFailure(c, err)
+		openAIError(c, status, msg, "upstream_error")
+		return
+	}
+	defer resp.Body.Close()
+
+	forwardUpstreamResponse(c, resp, "openai")
+}
+
+// proxyGeminiEmbeddings 将 OpenAI embeddings 请求转换为 Gemini batchEmbedContents
+// batchEmbedContents 不返回用量，输入 tokens 按文本字节数 / 4 估算 (与上下文窗口检查一致)
+func proxyGeminiEmbeddings(c *gin.Context, target *proxyTarget, requestData map[string]interface{}) {
+	inputs := embeddingInputs(requestData["input"])
+	if len(inputs) == 0 {
+		openAIRequestError(c, http.StatusBadRequest, "input must be a string or an array of strings")
+		return
+	}
+	dimensions, _ := requestData["dimensions"].(float64)
+
+	promptTokens := 0
+	upstreamReq := geminiEmbeddingRequest{}
+	for _, text := range inputs {
+		promptTokens += (len(text) + 3) / 4
+		upstreamReq.Requests = append(upstreamReq.Requests, geminiEmbedContent{
+			Model:                "models/" + target.ModelID,
+			Content:              geminiContent{Parts: []geminiPart{{Text: text}}},
+			OutputDimensionality: int(dimensions),
+		})
+	}
+
+	body, err := json.Marshal(upstreamReq)
+	if err != nil {
+		openAIRequestError(c, http.StatusInternalServerError, "Failed to process request")
+		return
+	}
+
+	proxyReq, err := newUpstreamRequest(c.Request.Context(), upstreamURL(target, "/v1beta/models/"+target.ModelID+":batchEmbedContents"), body)
+	if err != nil {
+		openAIRequestError(c, http.StatusInternalServerError, "Failed to create proxy request")
+		return
+	}
+
+	resp, err := doUpstream(target, proxyReq)
+	if err != nil {
+		status, msg := upstreamFailure(c, err)
+		openAIError(c, status, msg, "upstream_error")
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		openAIRequestError(c, http.StatusInternalServerError, "Failed to read response")
+		return
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		openAIError(c, resp.StatusCode, upstreamErrorMessage(respBody), "upstream_error")
+		return
+	}
+
+	var geminiResp struct {
+		Embeddings []struct {
+			Values []float64 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := json.Unmarshal(respBody, &geminiResp); err != nil {
+		openAIError(c, http.StatusBadGateway, "Invalid upstream response", "upstream_error")
+		return
+	}
+
+	if usage := usageFromContext(c); usage != nil {
+		usage.record(promptTokens, 0, promptTokens, 0)
+	}
+
+	data := make([]gin.H, 0, len(geminiResp.Embeddings))
+	for i, e := range geminiResp.Embeddings {
+		data = append(data, gin.H{"object": "embedding", "index": i, "embedding": e.Values})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+		"model":  target.ModelID,
+		"usage":  gin.H{"prompt_tokens": promptTokens, "total_tokens": promptTokens},
+	})
+}
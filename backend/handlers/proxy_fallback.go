@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// servedModelHeader 响应头，标明实际处理请求的 Provider 与模型 (providerId/modelId)
+const servedModelHeader = "X-Chatbox-Served-Model"
+
+// fallbackChain 返回目标模型及其可用的备用模型，按配置顺序排列
+// apiStyle 不为空时只保留该风格的备用模型；类型不同、重复或没有可用 Key 的备用模型会被跳过
+func fallbackChain(primary *proxyTarget, apiStyle string) []*proxyTarget {
+	chain := []*proxyTarget{primary}
+	if primary.Model == nil || len(primary.Model.Fallbacks) == 0 {
+		return chain
+	}
+
+	seen := map[string]bool{primary.servedModel(): true}
+	for _, ref := range primary.Model.Fallbacks {
+		t, err := primary.catalog.resolve(ref, apiStyle)
+		if err != nil {
+			log.Printf("[Fallback] skip %s for %s: %v", ref, primary.servedModel(), err)
+			continue
+		}
+		if seen[t.servedModel()] || t.ModelType() != primary.ModelType() || len(t.Keys) == 0 {
+			continue
+		}
+		seen[t.servedModel()] = true
+		chain = append(chain, t)
+	}
+	return chain
+}
+
+// servedModel 目标的完整模型名 (providerId/modelId)
+func (t *proxyTarget) servedModel() string {
+	return t.Provider.ProviderID + "/" + t.ModelID
+}
+
+// shouldFallback 上游返回 5xx 时切换到下一个备用模型
+func shouldFallback(status int) bool {
+	return status >= http.StatusInternalServerError
+}
+
+// peekFirstEvent 读取流式响应的第一个 SSE 事件，已读取的数据重新拼接回响应体
+// 上游在第一个事件之前出错或断开时返回错误；此时还没有向客户端写入任何数据，可以切换到下一个模型
+func peekFirstEvent(resp *http.Response) error {
+	sr := newSSEReader(resp.Body)
+	var buffered []byte
+	for {
+		ev, raw, err := sr.next()
+		buffered = append(buffered, raw...)
+		if err == io.EOF {
+			return errors.New("upstream stream closed before the first event")
+		}
+		if err != nil {
+			return err
+		}
+		// 跳过只有注释的保活事件
+		if ev.Data != "" || ev.Event != "" || len(buffered) > maxSSEEventSize {
+			break
+		}
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buffered), sr.r), resp.Body}
+	return nil
+}
+
+// doUpstreamWithFallback 按备用链依次请求上游，直到某个模型返回非 5xx 响应
+// 连接失败、超时或 5xx 时尝试下一个模型；流式响应在返回前先读取第一个事件，
+// 上游在第一个事件之前出错或断开同样切换到下一个模型
+// build 为每个目标构建上游请求，返回实际处理请求的目标，并写入 servedModelHeader
+func doUpstreamWithFallback(c *gin.Context, chain []*proxyTarget, build func(t *proxyTarget) (*http.Request, error)) (*http.Response, *proxyTarget, error) {
+	var lastErr error
+	for i, t := range chain {
+		req, err := build(t)
+		if err != nil {
+			return nil, t, err
+		}
+
+		resp, err := doUpstream(t, req)
+		last := i == len(chain)-1
+		if err == nil && !last && resp.StatusCode < http.StatusBadRequest && strings.Contains(resp.Header.Get("Content-Type"), "text/event-stream") {
+			if err = peekFirstEvent(resp); err != nil {
+				resp.Body.Close()
+				resp = nil
+			}
+		}
+		if err == nil && (!shouldFallback(resp.StatusCode) || last) {
+			if i > 0 {
+				log.Printf("[Fallback] %s served by %s", chain[0].servedModel(), t.servedModel())
+			}
+			c.Header(servedModelHeader, t.servedModel())
+			if usage := usageFromContext(c); usage != nil {
+				usage.target = t
+			}
+			return resp, t, nil
+		}
+
+		// 客户端已断开，不再继续尝试
+		if c.Request.Context().Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, t, c.Request.Context().Err()
+		}
+
+		if err != nil {
+			lastErr = err
+			log.Printf("[Fallback] %s failed: %v", t.servedModel(), err)
+		} else {
+			log.Printf("[Fallback] %s returned %d", t.servedModel(), resp.StatusCode)
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+	}
+	return nil, chain[len(chain)-1], lastErr
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"chatbox-backend/models"
+	"chatbox-backend/upstream"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newStreamTarget(id int64, host string) *proxyTarget {
+	return &proxyTarget{
+		Provider: &models.Provider{ID: id, ProviderID: host, APIStyle: "openai", APIHost: host},
+		Model:    &models.ProviderModel{ModelID: "m"},
+		ModelID:  "m",
+		Keys:     []upstream.Key{{ID: 0, Value: "sk-test", Weight: 1}},
+	}
+}
+
+func TestFallbackWhenStreamClosesBeforeFirstEvent(t *testing.T) {
+	// 返回 200 与 SSE 响应头后立即断开，没有任何事件
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+	}))
+	defer broken.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, ": ping\n\ndata: {\"id\":\"ok\"}\n\ndata: [DONE]\n\n")
+	}))
+	defer healthy.Close()
+
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/api/proxy/v1/chat/completions", nil)
+
+	chain := []*proxyTarget{newStreamTarget(-1, broken.URL), newStreamTarget(-2, healthy.URL)}
+	resp, served, err := doUpstreamWithFallback(c, chain, func(t *proxyTarget) (*http.Request, error) {
+		return newUpstreamRequest(c.Request.Context(), upstreamURL(t, "/v1/chat/completions"), []byte(`{}`))
+	})
+	if err != nil {
+		t.Fatalf("doUpstreamWithFallback() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if served != chain[1] {
+		t.Fatalf("served by %s, want the fallback target", served.servedModel())
+	}
+	// 预读的事件需要完整保留在响应体中
+	body, _ := io.ReadAll(resp.Body)
+	if want := ": ping\n\ndata: {\"id\":\"ok\"}\n\ndata: [DONE]\n\n"; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestCircuitOpenReturnsServiceUnavailable(t *testing.T) {
+	target := newStreamTarget(9102, "http://127.0.0.1:1")
+	defer upstream.ResetHealth(target.Provider.ID)
+	for i := 0; i < 20; i++ {
+		upstream.Record(target.Provider.ID, 0, http.StatusInternalServerError, 0, nil)
+	}
+
+	req, err := newUpstreamRequest(httptest.NewRequest("POST", "/", nil).Context(), upstreamURL(target, "/v1/chat/completions"), []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = doUpstream(target, req)
+	if !errors.Is(err, upstream.ErrCircuitOpen) {
+		t.Fatalf("doUpstream() error = %v, want ErrCircuitOpen", err)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	status, _ := upstreamFailure(c, err)
+	if status != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header not set")
+	}
+
+	status, _ = upstreamFailure(c, errors.New("connection refused"))
+	if status != http.StatusBadGateway {
+		t.Errorf("status for connection error = %d, want %d", status, http.StatusBadGateway)
+	}
+}
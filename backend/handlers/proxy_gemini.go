@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"chatbox-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiActions 允许代理的 Gemini 模型方法
+var geminiActions = map[string]bool{
+	"generateContent":       true,
+	"streamGenerateContent": true,
+}
+
+// geminiErrorStatus HTTP 状态码对应的 Google API 错误状态
+var geminiErrorStatus = map[int]string{
+	http.StatusBadRequest:          "INVALID_ARGUMENT",
+	http.StatusUnauthorized:        "UNAUTHENTICATED",
+	http.StatusForbidden:           "PERMISSION_DENIED",
+	http.StatusNotFound:            "NOT_FOUND",
+	http.StatusTooManyRequests:     "RESOURCE_EXHAUSTED",
+	http.StatusServiceUnavailable:  "UNAVAILABLE",
+	http.StatusGatewayTimeout:      "DEADLINE_EXCEEDED",
+	http.StatusInternalServerError: "INTERNAL",
+}
+
+// geminiError 以 Google API 的格式写入错误响应
+func geminiError(c *gin.Context, status int, message string) {
+	errStatus, ok := geminiErrorStatus[status]
+	if !ok {
+		errStatus = "UNKNOWN"
+	}
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"code":    status,
+			"message": message,
+			"status":  errStatus,
+		},
+	})
+}
+
+// ProxyGeminiGenerateContent 代理 Gemini generateContent / streamGenerateContent 请求
+// 模型名取自路径 (models/{model}:{action})，只匹配 apiStyle 为 google 的模型
+func ProxyGeminiGenerateContent(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		geminiError(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	version := c.Param("version")
+	if version != "v1" && version != "v1beta" {
+		geminiError(c, http.StatusNotFound, "Unsupported API version: "+version)
+		return
+	}
+
+	// 路径格式: {model}:{action}
+	modelAction := c.Param("modelAction")
+	idx := strings.LastIndex(modelAction, ":")
+	if idx <= 0 {
+		geminiError(c, http.StatusNotFound, "Invalid model path: "+modelAction)
+		return
+	}
+	model, action := modelAction[:idx], modelAction[idx+1:]
+	if !geminiActions[action] {
+		geminiError(c, http.StatusNotFound, "Unsupported method: "+action)
+		return
+	}
+
+	target, ok := resolveTargetOrError(c, model, "google", geminiError)
+	if !ok {
+		return
+	}
+	provider := target.Provider
+
+	// 请求体原样转发，只有 maxOutputTokens 超出模型限制时才重新序列化
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		geminiError(c, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	var requestData map[string]interface{}
+	if err := json.Unmarshal(body, &requestData); err != nil {
+		geminiError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// 检查模型能力与上下文窗口
+	features := parseRequestFeatures("google", requestData)
+	if msg := target.checkRequest(features); msg != "" {
+		geminiError(c, http.StatusBadRequest, msg)
+		return
+	}
+
+	log.Printf("[GeminiProxy] user=%d(%s) provider=%s model=%s action=%s", user.ID, user.Username, provider.ProviderID, target.ModelID, action)
+
+	usage := startUsage(c, user, target, "google", action == "streamGenerateContent")
+	defer usage.finish(c)
+
+	// 保留 alt=sse 等查询参数，去掉客户端可能附带的 key
+	query := url.Values{}
+	for k, v := range c.Request.URL.Query() {
+		if k != "key" {
+			query[k] = v
+		}
+	}
+
+	// 按备用链发送请求，只使用 google 风格的备用模型
+	resp, _, err := doUpstreamWithFallback(c, supportedChain(fallbackChain(target, "google"), features), func(t *proxyTarget) (*http.Request, error) {
+		targetURL := upstreamURL(t, "/"+version+"/models/"+t.ModelID+":"+action)
+		if len(query) > 0 {
+			targetURL += "?" + query.Encode()
+		}
+		upstreamBody := body
+		if data := cloneJSON(requestData); applyMaxTokens(data, "google", t, features) {
+			b, err := json.Marshal(data)
+			if err != nil {
+				return nil, err
+			}
+			upstreamBody = b
+		}
+		return newUpstreamRequest(c.Request.Context(), targetURL, upstreamBody)
+	})
+	if err != nil {
+		status, msg := upstreamFailure(c, err)
+		geminiError(c, status, msg)
+		return
+	}
+	defer resp.Body.Close()
+
+	// 网关返回的非 JSON 错误需要包装为 Google API 格式
+	if resp.StatusCode >= http.StatusBadRequest && !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		geminiError(c, resp.StatusCode, "Upstream error: "+strings.TrimSpace(string(respBody)))
+		return
+	}
+
+	forwardUpstreamResponse(c, resp, "google")
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// estimatedImageTokens 估算上下文长度时每张图片计入的 tokens
+const estimatedImageTokens = 1000
+
+// requestFeatures 请求用到的模型能力与 tokens 估算，用于在转发前检查模型的能力与限制
+type requestFeatures struct {
+	Images       int  // 图片数量，需要 vision 能力
+	Tools        bool // 是否声明了工具，需要 tool_use 能力
+	MaxTokens    int  // 请求的最大输出 tokens，0 表示未指定
+	PromptTokens int  // 估算的输入 tokens
+}
+
+// maxTokensFields 各 API 风格请求中表示最大输出 tokens 的字段路径
+var maxTokensFields = map[string][][]string{
+	"openai":    {{"max_tokens"}, {"max_completion_tokens"}},
+	"anthropic": {{"max_tokens"}},
+	"google":    {{"generationConfig", "maxOutputTokens"}},
+}
+
+// parseRequestFeatures 按 API 风格从请求体中提取图片、工具与最大输出 tokens，并估算输入 tokens
+func parseRequestFeatures(apiStyle string, data map[string]interface{}) requestFeatures {
+	var f requestFeatures
+
+	switch apiStyle {
+	case "anthropic":
+		for _, msg := range jsonArray(data["messages"]) {
+			m, _ := msg.(map[string]interface{})
+			for _, block := range jsonArray(m["content"]) {
+				if b, _ := block.(map[string]interface{}); b["type"] == "image" {
+					f.Images++
+				}
+			}
+		}
+		f.Tools = len(jsonArray(data["tools"])) > 0
+	case "google":
+		for _, content := range jsonArray(data["contents"]) {
+			ct, _ := content.(map[string]interface{})
+			for _, part := range jsonArray(ct["parts"]) {
+				p, _ := part.(map[string]interface{})
+				for _, key := range []string{"inlineData", "fileData"} {
+					if blob, ok := p[key].(map[string]interface{}); ok {
+						if mimeType, _ := blob["mimeType"].(string); strings.HasPrefix(mimeType, "image/") {
+							f.Images++
+						}
+					}
+				}
+			}
+		}
+		// googleSearch 等内置工具不需要 tool_use 能力
+		for _, tool := range jsonArray(data["tools"]) {
+			if t, _ := tool.(map[string]interface{}); len(jsonArray(t["functionDeclarations"])) > 0 {
+				f.Tools = true
+			}
+		}
+	default:
+		for _, msg := range jsonArray(data["messages"]) {
+			m, _ := msg.(map[string]interface{})
+			for _, part := range jsonArray(m["content"]) {
+				if p, _ := part.(map[string]interface{}); p["type"] == "image_url" {
+					f.Images++
+				}
+			}
+		}
+		f.Tools = len(jsonArray(data["tools"])) > 0 || len(jsonArray(data["functions"])) > 0
+	}
+
+	// 多个字段同时存在时以后面的字段为准 (max_completion_tokens 优先于 max_tokens)
+	for _, path := range maxTokensFields[apiStyle] {
+		if n, ok := jsonNumberAt(data, path); ok && n > 0 {
+			f.MaxTokens = n
+		}
+	}
+
+	f.PromptTokens = estimateTextBytes("", data)/4 + f.Images*estimatedImageTokens
+	return f
+}
+
+// estimateTextBytes 统计请求中文本的字节数，图片等 base64 数据不计入
+func estimateTextBytes(key string, v interface{}) int {
+	switch v := v.(type) {
+	case string:
+		if key == "data" || strings.HasPrefix(v, "data:") {
+			return 0
+		}
+		return len(v)
+	case map[string]interface{}:
+		n := 0
+		for k, item := range v {
+			n += estimateTextBytes(k, item)
+		}
+		return n
+	case []interface{}:
+		n := 0
+		for _, item := range v {
+			n += estimateTextBytes(key, item)
+		}
+		return n
+	}
+	return 0
+}
+
+// lacksCapability 模型声明了能力列表但其中没有指定能力
+// 声明的能力列表视为完整列表 (见 models.ProviderModel.Capabilities)；
+// 没有配置能力列表的模型视为能力未知，不做限制 (兼容升级前添加的模型)
+func (t *proxyTarget) lacksCapability(capability string) bool {
+	if t.Model == nil || len(t.Model.Capabilities) == 0 {
+		return false
+	}
+	for _, c := range t.Model.Capabilities {
+		if c == capability {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRequest 检查请求是否超出目标模型的能力与上下文窗口，返回描述性的错误信息，通过时返回空字符串
+// 只检查模型配置中声明了的能力与限制
+// 最大输出 tokens 超出 MaxOutput 时不视为错误，转发时按 limitMaxTokens 截断
+func (t *proxyTarget) checkRequest(f requestFeatures) string {
+	if f.Images > 0 && t.lacksCapability("vision") {
+		return fmt.Sprintf("Model %s does not support image input (vision)", t.ModelID)
+	}
+	if f.Tools && t.lacksCapability("tool_use") {
+		return fmt.Sprintf("Model %s does not support tools (tool_use)", t.ModelID)
+	}
+	if t.Model != nil && t.Model.ContextWindow > 0 && f.PromptTokens > t.Model.ContextWindow {
+		return fmt.Sprintf("Request is too long for model %s: estimated %d prompt tokens exceeds the context window of %d tokens",
+			t.ModelID, f.PromptTokens, t.Model.ContextWindow)
+	}
+	return ""
+}
+
+// limitMaxTokens 返回不超过目标模型 MaxOutput 的最大输出 tokens
+func (t *proxyTarget) limitMaxTokens(n int) int {
+	if t.Model != nil && t.Model.MaxOutput > 0 && n > t.Model.MaxOutput {
+		return t.Model.MaxOutput
+	}
+	return n
+}
+
+// applyMaxTokens 按目标模型的 MaxOutput 设置请求体中的最大输出 tokens，返回是否与原始请求不同
+// 会修改 data，备用链中的每个模型需要使用原始请求的副本 (cloneJSON)
+func applyMaxTokens(data map[string]interface{}, apiStyle string, t *proxyTarget, f requestFeatures) bool {
+	if f.MaxTokens <= 0 {
+		return false
+	}
+	n := t.limitMaxTokens(f.MaxTokens)
+
+	for _, path := range maxTokensFields[apiStyle] {
+		if current, ok := jsonNumberAt(data, path); ok && current > 0 {
+			parent := data
+			for _, key := range path[:len(path)-1] {
+				parent, _ = parent[key].(map[string]interface{})
+			}
+			parent[path[len(path)-1]] = n
+		}
+	}
+	return n != f.MaxTokens
+}
+
+// supportedChain 去掉备用链中不满足请求能力与上下文要求的备用模型，第一个目标保持不变
+func supportedChain(chain []*proxyTarget, f requestFeatures) []*proxyTarget {
+	out := []*proxyTarget{chain[0]}
+	for _, t := range chain[1:] {
+		if t.checkRequest(f) == "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// cloneJSON 深拷贝解析后的 JSON 对象，备用链中每个目标基于原始请求各自修改请求体
+func cloneJSON(data map[string]interface{}) map[string]interface{} {
+	return cloneJSONValue(data).(map[string]interface{})
+}
+
+func cloneJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = cloneJSONValue(item)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(v))
+		for i, item := range v {
+			arr[i] = cloneJSONValue(item)
+		}
+		return arr
+	}
+	return v
+}
+
+func jsonArray(v interface{}) []interface{} {
+	arr, _ := v.([]interface{})
+	return arr
+}
+
+// jsonNumberAt 读取嵌套路径上的数值字段
+func jsonNumberAt(data map[string]interface{}, path []string) (int, bool) {
+	var v interface{} = data
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		v = m[key]
+	}
+	switch n := v.(type) {
+	case float64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"testing"
+
+	"chatbox-backend/models"
+)
+
+func TestCheckRequestCapabilities(t *testing.T) {
+	vision := requestFeatures{Images: 1}
+	tools := requestFeatures{Tools: true}
+
+	tests := []struct {
+		name         string
+		capabilities []string
+		features     requestFeatures
+		wantErr      bool
+	}{
+		{"unconfigured model accepts images", nil, vision, false},
+		{"unconfigured model accepts tools", nil, tools, false},
+		{"declared vision accepts images", []string{"vision"}, vision, false},
+		{"declared capabilities without vision", []string{"tool_use"}, vision, true},
+		{"declared capabilities without tool_use", []string{"vision"}, tools, true},
+		{"reasoning-only list rejects tools", []string{"reasoning"}, tools, true},
+		{"reasoning-only list rejects images", []string{"reasoning"}, vision, true},
+		{"declared tool_use accepts tools", []string{"reasoning", "tool_use"}, tools, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &proxyTarget{
+				Provider: &models.Provider{ProviderID: "test"},
+				Model:    &models.ProviderModel{ModelID: "m", Capabilities: tt.capabilities},
+				ModelID:  "m",
+			}
+			if msg := target.checkRequest(tt.features); (msg != "") != tt.wantErr {
+				t.Errorf("checkRequest() = %q, wantErr %v", msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSupportedChainKeepsUnconfiguredFallback(t *testing.T) {
+	newTarget := func(capabilities ...string) *proxyTarget {
+		return &proxyTarget{
+			Provider: &models.Provider{ProviderID: "test"},
+			Model:    &models.ProviderModel{ModelID: "m", Capabilities: capabilities},
+			ModelID:  "m",
+		}
+	}
+	chain := []*proxyTarget{newTarget("vision"), newTarget(), newTarget("tool_use")}
+
+	got := supportedChain(chain, requestFeatures{Images: 1})
+	if len(got) != 2 || got[1] != chain[1] {
+		t.Fatalf("supportedChain() kept %d targets, want primary and the unconfigured fallback", len(got))
+	}
+}
+
+func TestApplyMaxTokensOnCloneKeepsOriginal(t *testing.T) {
+	requestData := map[string]interface{}{
+		"generationConfig": map[string]interface{}{"maxOutputTokens": float64(8000)},
+	}
+	features := requestFeatures{MaxTokens: 8000}
+	limited := &proxyTarget{Model: &models.ProviderModel{ModelID: "small", MaxOutput: 4096}}
+
+	data := cloneJSON(requestData)
+	if !applyMaxTokens(data, "google", limited, features) {
+		t.Fatal("applyMaxTokens() = false, want true for a smaller MaxOutput")
+	}
+	if got, _ := jsonNumberAt(data, []string{"generationConfig", "maxOutputTokens"}); got != 4096 {
+		t.Errorf("clone maxOutputTokens = %v, want 4096", got)
+	}
+	if got, _ := jsonNumberAt(requestData, []string{"generationConfig", "maxOutputTokens"}); got != 8000 {
+		t.Errorf("original maxOutputTokens = %v, want 8000", got)
+	}
+}
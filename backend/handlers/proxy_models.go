@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+
+	"chatbox-backend/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// proxyModel OpenAI 格式的模型对象，附带系统 Provider 的模型元数据
+type proxyModel struct {
+	ID            string             `json:"id"`
+	Object        string             `json:"object"`
+	Created       int64              `json:"created"`
+	OwnedBy       string             `json:"owned_by"`
+	Name          string             `json:"name,omitempty"`
+	Type          string             `json:"type"`
+	APIStyle      string             `json:"apiStyle"`
+	Capabilities  []string           `json:"capabilities"`
+	ContextWindow int                `json:"contextWindow,omitempty"`
+	MaxOutput     int                `json:"maxOutput,omitempty"`
+	Provider      proxyModelProvider `json:"provider"`
+}
+
+type proxyModelProvider struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ProxyListModels 列出当前用户可通过代理调用的模型 (OpenAI /v1/models 格式)
+// 只列出有可用 Key (系统 Key 或用户的个人 Key) 的模型；与代理解析规则共用 providerCatalog，
+// 模型 ID 会被解析到其他 Provider 时使用 providerId/modelId 作为 ID；
+// 与 resolveTargetOrError 一致，配额用尽时只列出可以使用个人 Key 的模型
+func ProxyListModels(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	pc, err := loadProviderCatalog(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get providers"})
+		return
+	}
+	if middleware.ExceededQuota(user) != nil {
+		pc.personalKeysOnly()
+	}
+
+	data := []proxyModel{}
+	for i := range pc.providers {
+		p := &pc.providers[i]
+		if len(pc.keys[p.ID]) == 0 {
+			continue
+		}
+		for j := range p.Models {
+			target := &proxyTarget{Provider: p, Model: &p.Models[j], ModelID: p.Models[j].ModelID}
+
+			id := target.ModelID
+			if resolved, err := pc.resolve(id, ""); err != nil || resolved.Provider.ID != p.ID || resolved.Model != target.Model {
+				id = p.ProviderID + "/" + target.ModelID
+			}
+
+			capabilities := target.Model.Capabilities
+			if capabilities == nil {
+				capabilities = []string{}
+			}
+			data = append(data, proxyModel{
+				ID:            id,
+				Object:        "model",
+				Created:       p.CreatedAt.Unix(),
+				OwnedBy:       p.ProviderID,
+				Name:          target.Model.Nickname,
+				Type:          target.ModelType(),
+				APIStyle:      target.APIStyle(),
+				Capabilities:  capabilities,
+				ContextWindow: target.Model.ContextWindow,
+				MaxOutput:     target.Model.MaxOutput,
+				Provider:      proxyModelProvider{ID: p.ProviderID, Name: p.Name},
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"chatbox-backend/models"
+	"chatbox-backend/upstream"
+)
+
+var errModelNotFound = errors.New("model not found")
+
+// proxyTarget 代理请求解析出的上游目标
+type proxyTarget struct {
+	Provider *models.Provider
+	Model    *models.ProviderModel
+	ModelID  string // 实际发送给上游的模型 ID (已去掉 provider 前缀)
+	Keys     []upstream.Key
+	// KeySource 实际处理请求的 Key 来源 (system | user)，由 doUpstream 设置
+	KeySource string
+	// catalog 解析目标时加载的 Provider 与 Key，解析备用模型时复用
+	catalog *providerCatalog
+}
+
+// APIStyle 目标模型实际使用的 API 风格，模型级配置优先于 Provider
+func (t *proxyTarget) APIStyle() string {
+	if t.Model != nil && t.Model.APIStyle != "" {
+		return t.Model.APIStyle
+	}
+	if t.Provider.APIStyle != "" {
+		return t.Provider.APIStyle
+	}
+	return "openai"
+}
+
+// ModelType 目标模型的类型 (chat | embedding | rerank)，未配置时视为 chat
+func (t *proxyTarget) ModelType() string {
+	if t.Model != nil && t.Model.Type != "" {
+		return t.Model.Type
+	}
+	return "chat"
+}
+
+// providerCatalog 已启用的 Provider 及其对某个用户可用的 Key，代理解析模型与列出模型共用同一份规则
+type providerCatalog struct {
+	providers []models.Provider
+	keys      map[int64][]upstream.Key // 按 Provider ID，个人 Key 排在系统 Key 前面
+}
+
+// loadProviderCatalog 加载已启用的 Provider、系统 Key 与用户的个人 Key，userID 为 0 时只使用系统 Key
+// Provider 允许自定义 Key 且用户保存了个人 Key 时，个人 Key 排在最前；用户关闭了 SystemFallback 时只使用个人 Key
+func loadProviderCatalog(userID int64) (*providerCatalog, error) {
+	providers, err := models.GetEnabledProviders()
+	if err != nil {
+		return nil, err
+	}
+	pools, err := models.GetAllEnabledProviderKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	personal := map[int64]models.UserProviderKey{}
+	if userID != 0 {
+		userKeys, err := models.GetUserProviderKeys(userID)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range userKeys {
+			personal[k.ProviderID] = k
+		}
+	}
+
+	pc := &providerCatalog{providers: providers, keys: make(map[int64][]upstream.Key, len(providers))}
+	for i := range providers {
+		p := &providers[i]
+		keys := providerKeys(p, pools[p.ID])
+		if k, ok := personal[p.ID]; ok && p.AllowCustomKey {
+			key := upstream.Key{ID: k.ID, Value: k.APIKey, Weight: 1, Personal: true}
+			if k.SystemFallback {
+				keys = append([]upstream.Key{key}, keys...)
+			} else {
+				keys = []upstream.Key{key}
+			}
+		}
+		pc.keys[p.ID] = keys
+	}
+	return pc, nil
+}
+
+// personalKeysOnly 去掉所有系统 Key，只保留用户的个人 Key (配额用尽时使用)
+func (pc *providerCatalog) personalKeysOnly() {
+	for id, keys := range pc.keys {
+		var personal []upstream.Key
+		for _, k := range keys {
+			if k.Personal {
+				personal = append(personal, k)
+			}
+		}
+		pc.keys[id] = personal
+	}
+}
+
+// resolve 支持 "modelId" 与 "providerId/modelId" 两种写法，provider 前缀优先匹配
+// 多个 Provider 匹配时按 Provider 排序取第一个有可用 Key 的，都没有 Key 时返回第一个匹配项 (由调用方报告缺少 Key)
+// apiStyle 不为空时只匹配该风格的模型 (用于原生协议代理)
+func (pc *providerCatalog) resolve(model, apiStyle string) (*proxyTarget, error) {
+	if model == "" {
+		return nil, errModelNotFound
+	}
+
+	var candidates []*proxyTarget
+	match := func(p *models.Provider, modelID string) {
+		if m := p.FindModel(modelID); m != nil {
+			t := &proxyTarget{Provider: p, Model: m, ModelID: modelID, Keys: pc.keys[p.ID], catalog: pc}
+			if apiStyle == "" || t.APIStyle() == apiStyle {
+				candidates = append(candidates, t)
+			}
+		}
+	}
+
+	// 模型 ID 本身也可能包含 "/"，例如 openrouter 的 openai/gpt-4o
+	if prefix, rest, ok := strings.Cut(model, "/"); ok {
+		for i := range pc.providers {
+			if pc.providers[i].ProviderID == prefix {
+				match(&pc.providers[i], rest)
+			}
+		}
+	}
+	for i := range pc.providers {
+		match(&pc.providers[i], model)
+	}
+
+	if len(candidates) == 0 {
+		return nil, errModelNotFound
+	}
+	for _, t := range candidates {
+		if len(t.Keys) > 0 {
+			return t, nil
+		}
+	}
+	return candidates[0], nil
+}
+
+// providerKeys 组合 Provider 的默认 Key 与 Key 池中启用的 Key
+func providerKeys(p *models.Provider, pool []models.ProviderKey) []upstream.Key {
+	var keys []upstream.Key
+	if p.APIKey != "" {
+		keys = append(keys, upstream.Key{ID: 0, Value: p.APIKey, Weight: 1})
+	}
+	for _, k := range pool {
+		if k.Enabled && k.APIKey != "" {
+			keys = append(keys, upstream.Key{ID: k.ID, Value: k.APIKey, Weight: k.Weight})
+		}
+	}
+	return keys
+}
+
+// defaultAPIHosts 各 API 风格未配置 APIHost 时使用的官方地址
+var defaultAPIHosts = map[string]string{
+	"openai":    "https://api.openai.com",
+	"anthropic": "https://api.anthropic.com",
+	"google":    "https://generativelanguage.googleapis.com",
+}
+
+// upstreamURL 拼接目标 Provider 的 API 地址
+func upstreamURL(t *proxyTarget, path string) string {
+	apiHost := t.Provider.APIHost
+	if apiHost == "" {
+		apiHost = defaultAPIHosts[t.APIStyle()]
+	}
+	// 确保 apiHost 没有尾部斜杠
+	return strings.TrimSuffix(apiHost, "/") + path
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxSSEEventSize 单个 SSE 事件 (包括其中每一行) 的最大长度，图片 base64 等内容可能很长
+const maxSSEEventSize = 16 * 1024 * 1024
+
+var errSSEEventTooLarge = errors.New("SSE event exceeds the maximum size")
+
+// sseEvent 一个完整的 SSE 事件
+type sseEvent struct {
+	Event string
+	Data  string
+}
+
+// sseReader 逐个读取 SSE 事件，解析与透传共用，单个事件的长度不超过 maxSSEEventSize
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// next 读取下一个事件 (以空行结束，流结束时没有空行收尾的最后一个事件同样返回)
+// raw 为事件的原始字节 (包括注释行与结尾的空行)，用于原样透传；没有更多数据时返回 io.EOF
+// 读取出错时返回已读到的部分与错误
+func (s *sseReader) next() (ev sseEvent, raw []byte, err error) {
+	var data []string
+	for {
+		var line []byte
+		line, err = s.readLine(maxSSEEventSize - len(raw))
+		raw = append(raw, line...)
+
+		if trimmed := strings.TrimRight(string(line), "\r\n"); trimmed == "" {
+			if len(line) > 0 {
+				// 空行表示事件结束
+				ev.Data = strings.Join(data, "\n")
+				return ev, raw, nil
+			}
+		} else if !strings.HasPrefix(trimmed, ":") {
+			field, value, _ := strings.Cut(trimmed, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				ev.Event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+
+		if err != nil {
+			ev.Data = strings.Join(data, "\n")
+			if err == io.EOF && len(raw) > 0 {
+				return ev, raw, nil
+			}
+			return ev, raw, err
+		}
+	}
+}
+
+// readLine 读取一行 (包括行尾换行符)，超过 limit 时返回 errSSEEventTooLarge
+func (s *sseReader) readLine(limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := s.r.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return line, errSSEEventTooLarge
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+// readSSEEvents 逐个解析 SSE 事件，每读到一个包含 data 或 event 的事件调用一次 fn
+// fn 返回 false 时停止读取
+func readSSEEvents(r io.Reader, fn func(ev sseEvent) bool) error {
+	sr := newSSEReader(r)
+	for {
+		ev, _, err := sr.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if (ev.Data != "" || ev.Event != "") && !fn(ev) {
+			return nil
+		}
+	}
+}
+
+// streamUpstreamEvents 按事件透传上游 SSE 流，每个事件写完后 flush
+// 上游中途断开时丢弃不完整的事件并向客户端发送一个错误事件；客户端断开时 context 取消，读取随之结束
+// data 同时用于统计 tokens 用量，代理注入 include_usage 时去掉上游的 usage chunk
+func streamUpstreamEvents(c *gin.Context, resp *http.Response, clientStyle string) {
+	startSSE(c)
+	c.Status(resp.StatusCode)
+
+	usage := usageFromContext(c)
+	sr := newSSEReader(resp.Body)
+	for {
+		ev, raw, err := sr.next()
+		if err != nil {
+			if err != io.EOF && c.Request.Context().Err() == nil {
+				log.Printf("[StreamProxy] upstream stream interrupted: %v", err)
+				if usage != nil {
+					usage.fail("Upstream stream interrupted: " + err.Error())
+				}
+				writeStreamError(c, clientStyle, "Upstream stream interrupted: "+err.Error())
+			}
+			c.Writer.Flush()
+			return
+		}
+
+		if usage != nil && ev.Data != "" {
+			if msg := streamEventError(ev); msg != "" {
+				usage.fail(msg)
+			}
+			if usage.observe([]byte(ev.Data)) && usage.stripUsageChunk {
+				continue
+			}
+		}
+		if _, werr := c.Writer.Write(raw); werr != nil {
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// streamEventError 返回上游在流中发送的错误事件的错误信息，不是错误事件时返回空
+// (Anthropic 为 error 事件，OpenAI 与 Gemini 为带 error 字段的 data)
+func streamEventError(ev sseEvent) string {
+	if ev.Event != "error" && !strings.Contains(ev.Data, `"error"`) {
+		return ""
+	}
+	var payload struct {
+		Error json.RawMessage `json:"error"`
+	}
+	err := json.Unmarshal([]byte(ev.Data), &payload)
+	if ev.Event != "error" && (err != nil || len(payload.Error) == 0 || string(payload.Error) == "null") {
+		return ""
+	}
+	return upstreamErrorMessage([]byte(ev.Data))
+}
+
+// writeStreamError 按客户端协议格式写入流式错误事件
+func writeStreamError(c *gin.Context, clientStyle, message string) {
+	switch clientStyle {
+	case "anthropic":
+		payload, _ := json.Marshal(gin.H{"type": "error", "error": gin.H{"type": "api_error", "message": message}})
+		fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", payload)
+		c.Writer.Flush()
+	case "google":
+		writeSSEData(c, gin.H{"error": gin.H{"code": http.StatusBadGateway, "message": message, "status": "UNAVAILABLE"}})
+	default:
+		writeSSEData(c, gin.H{"error": gin.H{"message": message, "type": "upstream_error"}})
+	}
+}
+
+// startSSE 设置 SSE 响应头
+func startSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(200)
+}
+
+// writeSSEData 以 data: 行写入一个 JSON 事件并立即 flush
+func writeSSEData(c *gin.Context, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", payload); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// writeSSEDone 写入 OpenAI 流结束标记
+func writeSSEDone(c *gin.Context) {
+	fmt.Fprint(c.Writer, "data: [DONE]\n\n")
+	c.Writer.Flush()
+}
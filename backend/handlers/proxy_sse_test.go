@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadSSEEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  string
+		want    []sseEvent
+		wantErr error
+	}{
+		{
+			"single data line",
+			"data: {\"a\":1}\n\n",
+			[]sseEvent{{Data: `{"a":1}`}},
+			nil,
+		},
+		{
+			"multi-line data is joined with newlines",
+			"data: first\ndata: second\n\n",
+			[]sseEvent{{Data: "first\nsecond"}},
+			nil,
+		},
+		{
+			"CRLF line endings",
+			"event: message_start\r\ndata: {}\r\n\r\ndata: next\r\n\r\n",
+			[]sseEvent{{Event: "message_start", Data: "{}"}, {Data: "next"}},
+			nil,
+		},
+		{
+			"comments and empty events are skipped",
+			": keep-alive\n\n\ndata: x\n\n",
+			[]sseEvent{{Data: "x"}},
+			nil,
+		},
+		{
+			"last event without trailing blank line",
+			"data: a\n\ndata: b",
+			[]sseEvent{{Data: "a"}, {Data: "b"}},
+			nil,
+		},
+		{
+			"line over the size limit",
+			"data: " + strings.Repeat("x", maxSSEEventSize) + "\n\n",
+			nil,
+			errSSEEventTooLarge,
+		},
+		{
+			"event over the size limit across lines",
+			strings.Repeat("data: "+strings.Repeat("x", 1024*1024)+"\n", 17) + "\n",
+			nil,
+			errSSEEventTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []sseEvent
+			err := readSSEEvents(strings.NewReader(tt.stream), func(ev sseEvent) bool {
+				got = append(got, ev)
+				return true
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readSSEEvents() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readSSEEvents() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSEReaderKeepsRawBytes(t *testing.T) {
+	stream := ": ping\r\nevent: delta\r\ndata: {}\r\n\r\ndata: [DONE]\n\n"
+	sr := newSSEReader(strings.NewReader(stream))
+
+	var raw []byte
+	for {
+		_, chunk, err := sr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next() error = %v", err)
+		}
+		raw = append(raw, chunk...)
+	}
+	if string(raw) != stream {
+		t.Errorf("raw bytes = %q, want %q", raw, stream)
+	}
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chatStreamConverter 将上游流式事件转换为 OpenAI chunk
+type chatStreamConverter interface {
+	convert(data string) ([]*openAIChatResponse, string)
+	usageChunk() *openAIChatResponse
+}
+
+// upstreamErrorMessage 从上游错误响应中提取错误信息
+// 兼容 OpenAI / Anthropic / Google 的 {"error": {"message": ...}} 与 {"error": "..."} 格式
+func upstreamErrorMessage(body []byte) string {
+	var structured struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &structured); err == nil && structured.Error.Message != "" {
+		return structured.Error.Message
+	}
+	var plain struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &plain); err == nil && plain.Error != "" {
+		return plain.Error
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// parseChatRequest 将请求体解析为 OpenAI Chat Completions 请求，用于协议转换
+func parseChatRequest(requestData map[string]interface{}) (*openAIChatRequest, error) {
+	raw, err := json.Marshal(requestData)
+	if err != nil {
+		return nil, err
+	}
+	var req openAIChatRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// checkTranslation 检查 OpenAI 聊天请求能否转换为目标的 API 风格，不能转换时返回错误信息
+func (t *proxyTarget) checkTranslation(req *openAIChatRequest) string {
+	if t.APIStyle() == "google" {
+		return checkGeminiRequest(req)
+	}
+	return ""
+}
+
+// translatableChain 去掉备用链中无法转换请求的备用模型，第一个目标保持不变
+func translatableChain(chain []*proxyTarget, req *openAIChatRequest) []*proxyTarget {
+	out := []*proxyTarget{chain[0]}
+	for _, t := range chain[1:] {
+		if t.checkTranslation(req) == "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// newTranslatedChatRequest 将 OpenAI 格式的聊天请求转换为 anthropic / google 风格的上游请求
+func newTranslatedChatRequest(ctx context.Context, target *proxyTarget, req *openAIChatRequest) (*http.Request, error) {
+	var upstreamBody interface{}
+	var targetURL string
+	switch target.APIStyle() {
+	case "anthropic":
+		anthropicReq := toAnthropicRequest(req, target.ModelID, target.Model.MaxOutput)
+		anthropicReq.MaxTokens = target.limitMaxTokens(anthropicReq.MaxTokens)
+		upstreamBody = anthropicReq
+		targetURL = upstreamURL(target, "/v1/messages")
+	case "google":
+		geminiReq := toGeminiRequest(req)
+		if geminiReq.GenerationConfig != nil {
+			geminiReq.GenerationConfig.MaxOutputTokens = target.limitMaxTokens(geminiReq.GenerationConfig.MaxOutputTokens)
+		}
+		upstreamBody = geminiReq
+		action := ":generateContent"
+		if req.Stream {
+			action = ":streamGenerateContent?alt=sse"
+		}
+		targetURL = upstreamURL(target, "/v1beta/models/"+target.ModelID+action)
+	default:
+		return nil, fmt.Errorf("unsupported API style: %s", target.APIStyle())
+	}
+
+	body, err := json.Marshal(upstreamBody)
+	if err != nil {
+		return nil, err
+	}
+	return newUpstreamRequest(ctx, targetURL, body)
+}
+
+// writeTranslatedChatResponse 将 anthropic / google 风格的上游响应 (包括流式响应) 转换回 OpenAI 格式
+func writeTranslatedChatResponse(c *gin.Context, target *proxyTarget, req *openAIChatRequest, resp *http.Response) {
+	if resp.StatusCode >= http.StatusBadRequest {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		log.Printf("[ChatProxy] upstream %s returned %d: %s", target.Provider.ProviderID, resp.StatusCode, string(respBody))
+		openAIError(c, resp.StatusCode, upstreamErrorMessage(respBody), "upstream_error")
+		return
+	}
+
+	if req.Stream {
+		var conv chatStreamConverter
+		if target.APIStyle() == "anthropic" {
+			conv = newAnthropicStreamConverter(target.ModelID, time.Now().Unix())
+		} else {
+			conv = newGeminiStreamConverter(target.ModelID, time.Now().Unix())
+		}
+		streamTranslatedChat(c, resp.Body, conv, req.includeUsage())
+		if usage := usageFromContext(c); usage != nil {
+			usage.observeOpenAI(conv.usageChunk().Usage)
+		}
+		return
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		jsonError(c, http.StatusInternalServerError, "Failed to read response")
+		return
+	}
+
+	var out *openAIChatResponse
+	if target.APIStyle() == "anthropic" {
+		var anthropicResp anthropicResponse
+		if err := json.Unmarshal(respBody, &anthropicResp); err != nil {
+			openAIError(c, http.StatusBadGateway, "Invalid upstream response", "upstream_error")
+			return
+		}
+		out = fromAnthropicResponse(&anthropicResp, target.ModelID)
+	} else {
+		var geminiResp geminiResponse
+		if err := json.Unmarshal(respBody, &geminiResp); err != nil {
+			openAIError(c, http.StatusBadGateway, "Invalid upstream response", "upstream_error")
+			return
+		}
+		out = fromGeminiResponse(&geminiResp, target.ModelID)
+	}
+	if usage := usageFromContext(c); usage != nil {
+		usage.observeOpenAI(out.Usage)
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// streamTranslatedChat 读取上游 SSE 事件并以 OpenAI chunk 的形式逐个写给客户端
+func streamTranslatedChat(c *gin.Context, upstream io.Reader, conv chatStreamConverter, includeUsage bool) {
+	startSSE(c)
+
+	var streamErr string
+	err := readSSEEvents(upstream, func(ev sseEvent) bool {
+		chunks, errMsg := conv.convert(ev.Data)
+		if errMsg != "" {
+			streamErr = errMsg
+			return false
+		}
+		for _, chunk := range chunks {
+			if err := writeSSEData(c, chunk); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	// 客户端已断开，无需再写入
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if err != nil && streamErr == "" {
+		log.Printf("[ChatProxy] upstream stream interrupted: %v", err)
+		streamErr = "Upstream stream interrupted: " + err.Error()
+	}
+
+	if streamErr != "" {
+		if usage := usageFromContext(c); usage != nil {
+			usage.fail(streamErr)
+		}
+		writeStreamError(c, "openai", streamErr)
+	}
+	if includeUsage {
+		writeSSEData(c, conv.usageChunk())
+	}
+	writeSSEDone(c)
+}
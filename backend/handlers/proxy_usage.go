@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"chatbox-backend/middleware"
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// usageContextKey 请求 context 中保存 usageTracker 的键
+const usageContextKey = "usageTracker"
+
+// maxUsageErrorLength 用量记录中错误信息的最大长度 (error_message 列)
+const maxUsageErrorLength = 500
+
+// usageTracker 收集一次代理请求的 tokens 用量，请求结束时保存为 UsageRecord
+type usageTracker struct {
+	userID           int64
+	target           *proxyTarget // 实际处理请求的目标，备用模型生效时会被替换
+	endpoint         string
+	stream           bool
+	start            time.Time
+	promptTokens     int
+	completionTokens int
+	cachedTokens     int
+	totalTokens      int
+	images           int
+	// errMessage 响应头已发送后上游返回的错误或中断原因，此时客户端收到的状态码仍为 2xx
+	errMessage string
+	// stripUsageChunk 代理为统计用量注入了 stream_options.include_usage，
+	// 需要把上游最后的 usage chunk 从返回给客户端的流中去掉
+	stripUsageChunk bool
+}
+
+// startUsage 开始统计本次请求的用量，调用方需要 defer finish
+func startUsage(c *gin.Context, user *models.User, target *proxyTarget, endpoint string, stream bool) *usageTracker {
+	u := &usageTracker{
+		userID:   user.ID,
+		target:   target,
+		endpoint: endpoint,
+		stream:   stream,
+		start:    time.Now(),
+	}
+	c.Set(usageContextKey, u)
+	return u
+}
+
+// usageFromContext 获取当前请求的 usageTracker，未统计用量时返回 nil
+func usageFromContext(c *gin.Context) *usageTracker {
+	v, ok := c.Get(usageContextKey)
+	if !ok {
+		return nil
+	}
+	u, _ := v.(*usageTracker)
+	return u
+}
+
+// usagePayload 兼容各协议 usage 字段的解析结构
+type usagePayload struct {
+	Usage   *usageFields `json:"usage"`
+	Message *struct {
+		Usage *usageFields `json:"usage"`
+	} `json:"message"` // Anthropic message_start 事件
+	UsageMetadata *geminiUsageMetadata `json:"usageMetadata"` // Gemini
+	Choices       []json.RawMessage    `json:"choices"`
+}
+
+type usageFields struct {
+	PromptTokens        int                        `json:"prompt_tokens"`
+	CompletionTokens    int                        `json:"completion_tokens"`
+	TotalTokens         int                        `json:"total_tokens"`
+	PromptTokensDetails *openAIPromptTokensDetails `json:"prompt_tokens_details"`
+	anthropicUsage                                 // Anthropic / OpenAI 图片的 input_tokens 与 output_tokens
+}
+
+// observe 从上游响应体或流式事件的 data 中提取 usage，已有的数值会被非零值覆盖
+// (Anthropic 流式响应的输入与输出 tokens 分别在 message_start 与 message_delta 中)
+// Gemini streamGenerateContent 不带 alt=sse 时返回 JSON 数组，逐个元素提取
+// 返回 true 表示该事件是只包含 usage 的 OpenAI chunk
+func (u *usageTracker) observe(data []byte) bool {
+	if !bytes.Contains(data, []byte(`"usage`)) {
+		return false
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err == nil {
+			for _, item := range items {
+				u.observe(item)
+			}
+		}
+		return false
+	}
+	var p usagePayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return false
+	}
+
+	fields := p.Usage
+	if fields == nil && p.Message != nil {
+		fields = p.Message.Usage
+	}
+	if fields != nil {
+		cached := fields.CacheReadInputTokens
+		if fields.PromptTokensDetails != nil {
+			cached += fields.PromptTokensDetails.CachedTokens
+		}
+		u.record(fields.PromptTokens+fields.promptTokens(), fields.CompletionTokens+fields.OutputTokens, fields.TotalTokens, cached)
+	}
+	if m := p.UsageMetadata; m != nil {
+		u.record(m.PromptTokenCount, m.CandidatesTokenCount+m.ThoughtsTokenCount, m.TotalTokenCount, m.CachedContentTokenCount)
+	}
+
+	return p.Usage != nil && len(p.Choices) == 0 && p.Message == nil
+}
+
+// observeOpenAI 记录转换后的 OpenAI usage
+func (u *usageTracker) observeOpenAI(usage *openAIUsage) {
+	if usage != nil {
+		cached := 0
+		if usage.PromptTokensDetails != nil {
+			cached = usage.PromptTokensDetails.CachedTokens
+		}
+		u.record(usage.PromptTokens, usage.CompletionTokens, usage.TotalTokens, cached)
+	}
+}
+
+// observeImages 记录图片生成响应中的图片数量
+func (u *usageTracker) observeImages(data []byte) {
+	var resp struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &resp); err == nil {
+		u.images = len(resp.Data)
+	}
+}
+
+// fail 记录流式响应开始后发生的上游错误，只保留第一个错误
+func (u *usageTracker) fail(message string) {
+	if u.errMessage == "" {
+		u.errMessage = message
+	}
+}
+
+func (u *usageTracker) record(prompt, completion, total, cached int) {
+	if prompt > 0 {
+		u.promptTokens = prompt
+	}
+	if completion > 0 {
+		u.completionTokens = completion
+	}
+	if total > 0 {
+		u.totalTokens = total
+	}
+	if cached > 0 {
+		u.cachedTokens = cached
+	}
+}
+
+// finish 保存用量记录，在请求处理结束时调用
+func (u *usageTracker) finish(c *gin.Context) {
+	total := u.totalTokens
+	if total < u.promptTokens+u.completionTokens {
+		total = u.promptTokens + u.completionTokens
+	}
+
+	if u.target.KeySource == "" {
+		u.target.KeySource = models.KeySourceSystem
+	}
+
+	// 流式响应中途失败时状态码已经写出，按 502 记录以计入错误率
+	status := c.Writer.Status()
+	if u.errMessage != "" && status < http.StatusBadRequest {
+		status = http.StatusBadGateway
+	}
+	errMessage := u.errMessage
+	if len(errMessage) > maxUsageErrorLength {
+		errMessage = strings.ToValidUTF8(errMessage[:maxUsageErrorLength], "")
+	}
+
+	var cost float64
+	if u.target.Model != nil {
+		cost = u.target.Model.Cost(u.promptTokens, u.cachedTokens, u.completionTokens, u.images)
+	}
+
+	record := &models.UsageRecord{
+		UserID:           u.userID,
+		ProviderID:       u.target.Provider.ID,
+		Provider:         u.target.Provider.ProviderID,
+		Model:            u.target.ModelID,
+		Endpoint:         u.endpoint,
+		KeySource:        u.target.KeySource,
+		PromptTokens:     u.promptTokens,
+		CompletionTokens: u.completionTokens,
+		CachedTokens:     u.cachedTokens,
+		TotalTokens:      total,
+		ImageCount:       u.images,
+		Cost:             cost,
+		LatencyMs:        time.Since(u.start).Milliseconds(),
+		StatusCode:       status,
+		ErrorMessage:     errMessage,
+		Stream:           u.stream,
+	}
+
+	middleware.SetActualTokens(c, total)
+
+	// 响应已经写完，异步保存避免阻塞连接
+	go func() {
+		if err := models.CreateUsageRecord(record); err != nil {
+			log.Printf("[Usage] failed to save usage record for user %d: %v", record.UserID, err)
+		}
+	}()
+}
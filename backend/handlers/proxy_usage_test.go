@@ -0,0 +1,46 @@
+package handlers
+
+import "testing"
+
+func TestUsageObserve(t *testing.T) {
+	tests := []struct {
+		name                       string
+		data                       string
+		wantPrompt, wantCompletion int
+		wantTotal                  int
+	}{
+		{
+			name:       "openai",
+			data:       `{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`,
+			wantPrompt: 10, wantCompletion: 5, wantTotal: 15,
+		},
+		{
+			name:       "gemini object",
+			data:       `{"candidates":[],"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":3,"totalTokenCount":10}}`,
+			wantPrompt: 7, wantCompletion: 3, wantTotal: 10,
+		},
+		{
+			// streamGenerateContent 不带 alt=sse 时返回 JSON 数组，usageMetadata 为累计值
+			name: "gemini stream array",
+			data: `[
+				{"candidates":[{"content":{"parts":[{"text":"Hel"}]}}],"usageMetadata":{"promptTokenCount":7,"totalTokenCount":7}},
+				{"candidates":[{"content":{"parts":[{"text":"lo"}]}}],"usageMetadata":{"promptTokenCount":7,"candidatesTokenCount":4,"totalTokenCount":11}}
+			]`,
+			wantPrompt: 7, wantCompletion: 4, wantTotal: 11,
+		},
+		{
+			name: "no usage",
+			data: `[{"candidates":[]}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &usageTracker{}
+			u.observe([]byte(tt.data))
+			if u.promptTokens != tt.wantPrompt || u.completionTokens != tt.wantCompletion || u.totalTokens != tt.wantTotal {
+				t.Errorf("observe() = prompt %d, completion %d, total %d; want %d, %d, %d",
+					u.promptTokens, u.completionTokens, u.totalTokens, tt.wantPrompt, tt.wantCompletion, tt.wantTotal)
+			}
+		})
+	}
+}
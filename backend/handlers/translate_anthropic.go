@@ -0,0 +1,384 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+)
+
+// OpenAI Chat Completions <-> Anthropic Messages 协议转换
+
+// defaultAnthropicMaxTokens Anthropic 要求必须指定 max_tokens，请求和模型配置都没有时使用
+const defaultAnthropicMaxTokens = 4096
+
+type anthropicRequest struct {
+	Model         string                 `json:"model"`
+	System        string                 `json:"system,omitempty"`
+	Messages      []anthropicMessage     `json:"messages"`
+	MaxTokens     int                    `json:"max_tokens"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	StopSequences []string               `json:"stop_sequences,omitempty"`
+	Stream        bool                   `json:"stream,omitempty"`
+	Tools         []anthropicTool        `json:"tools,omitempty"`
+	ToolChoice    map[string]interface{} `json:"tool_choice,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicContentBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Thinking  string                `json:"thinking,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // base64 | url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// promptTokens 输入 tokens 总数，Anthropic 的 input_tokens 不包含缓存写入与缓存命中的部分
+func (u anthropicUsage) promptTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+type anthropicResponse struct {
+	ID         string                  `json:"id"`
+	Model      string                  `json:"model"`
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+// anthropicStopReasons Anthropic stop_reason 对应的 OpenAI finish_reason
+var anthropicStopReasons = map[string]string{
+	"end_turn":      "stop",
+	"stop_sequence": "stop",
+	"max_tokens":    "length",
+	"tool_use":      "tool_calls",
+	"refusal":       "content_filter",
+}
+
+func anthropicFinishReason(stopReason string) string {
+	if reason, ok := anthropicStopReasons[stopReason]; ok {
+		return reason
+	}
+	return "stop"
+}
+
+// anthropicTemperature OpenAI 的 temperature 范围为 0-2，Anthropic 为 0-1，超出范围时截断
+func anthropicTemperature(t *float64) *float64 {
+	if t == nil {
+		return nil
+	}
+	v := math.Min(math.Max(*t, 0), 1)
+	return &v
+}
+
+// toAnthropicRequest 将 OpenAI 聊天请求转换为 Anthropic Messages 请求
+func toAnthropicRequest(req *openAIChatRequest, modelID string, defaultMaxTokens int) *anthropicRequest {
+	out := &anthropicRequest{
+		Model:         modelID,
+		MaxTokens:     req.maxOutputTokens(),
+		Temperature:   anthropicTemperature(req.Temperature),
+		TopP:          req.TopP,
+		StopSequences: req.stopSequences(),
+		Stream:        req.Stream,
+	}
+	if out.MaxTokens <= 0 {
+		out.MaxTokens = defaultMaxTokens
+	}
+	if out.MaxTokens <= 0 {
+		out.MaxTokens = defaultAnthropicMaxTokens
+	}
+
+	var system []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			if text := msg.textContent(); text != "" {
+				system = append(system, text)
+			}
+		case "user":
+			out.appendMessage("user", anthropicBlocksFromParts(msg.contentParts()))
+		case "assistant":
+			var blocks []anthropicContentBlock
+			if text := msg.textContent(); text != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: text})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+			out.appendMessage("assistant", blocks)
+		case "tool":
+			// 工具结果在 Anthropic 中以 user 消息的 tool_result 块表示
+			out.appendMessage("user", []anthropicContentBlock{{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.textContent(),
+			}})
+		}
+	}
+	out.System = strings.Join(system, "\n\n")
+
+	for _, tool := range req.Tools {
+		schema := tool.Function.Parameters
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		out.Tools = append(out.Tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	switch mode, name := req.toolChoice(); mode {
+	case "auto":
+		out.ToolChoice = map[string]interface{}{"type": "auto"}
+	case "required":
+		out.ToolChoice = map[string]interface{}{"type": "any"}
+	case "none":
+		out.ToolChoice = map[string]interface{}{"type": "none"}
+	case "function":
+		out.ToolChoice = map[string]interface{}{"type": "tool", "name": name}
+	}
+	if len(out.Tools) == 0 {
+		out.ToolChoice = nil
+	}
+
+	return out
+}
+
+// appendMessage 追加消息，Anthropic 要求 user / assistant 交替出现，相同角色的相邻消息合并
+func (r *anthropicRequest) appendMessage(role string, blocks []anthropicContentBlock) {
+	if len(blocks) == 0 {
+		return
+	}
+	if n := len(r.Messages); n > 0 && r.Messages[n-1].Role == role {
+		r.Messages[n-1].Content = append(r.Messages[n-1].Content, blocks...)
+		return
+	}
+	r.Messages = append(r.Messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+// anthropicBlocksFromParts 将 OpenAI 内容分段转换为 Anthropic 内容块
+func anthropicBlocksFromParts(parts []openAIContentPart) []anthropicContentBlock {
+	var blocks []anthropicContentBlock
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			if part.Text != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: part.Text})
+			}
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			source := &anthropicImageSource{Type: "url", URL: part.ImageURL.URL}
+			if mimeType, data, ok := parseDataURL(part.ImageURL.URL); ok {
+				source = &anthropicImageSource{Type: "base64", MediaType: mimeType, Data: data}
+			}
+			blocks = append(blocks, anthropicContentBlock{Type: "image", Source: source})
+		}
+	}
+	return blocks
+}
+
+// fromAnthropicResponse 将 Anthropic 非流式响应转换为 OpenAI 格式
+func fromAnthropicResponse(resp *anthropicResponse, model string) *openAIChatResponse {
+	var text, reasoning strings.Builder
+	var toolCalls []openAIToolCall
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			text.WriteString(block.Text)
+		case "thinking":
+			reasoning.WriteString(block.Thinking)
+		case "tool_use":
+			call := openAIToolCall{ID: block.ID, Type: "function"}
+			call.Function.Name = block.Name
+			call.Function.Arguments = string(block.Input)
+			toolCalls = append(toolCalls, call)
+		}
+	}
+
+	out := newOpenAICompletion(resp.ID, model)
+	finishReason := anthropicFinishReason(resp.StopReason)
+	out.Choices = []openAIChoice{{
+		Index: 0,
+		Message: &openAIResponseMessage{
+			Role:             "assistant",
+			Content:          stringPtr(text.String()),
+			ReasoningContent: reasoning.String(),
+			ToolCalls:        toolCalls,
+		},
+		FinishReason: &finishReason,
+	}}
+	out.Usage = &openAIUsage{
+		PromptTokens:        resp.Usage.promptTokens(),
+		CompletionTokens:    resp.Usage.OutputTokens,
+		TotalTokens:         resp.Usage.promptTokens() + resp.Usage.OutputTokens,
+		PromptTokensDetails: cachedPromptTokens(resp.Usage.CacheReadInputTokens),
+	}
+	return out
+}
+
+// anthropicStreamEvent Anthropic 流式事件中用到的字段
+type anthropicStreamEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message *struct {
+		ID    string         `json:"id"`
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message,omitempty"`
+	ContentBlock *anthropicContentBlock `json:"content_block,omitempty"`
+	Delta        *struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta,omitempty"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// anthropicStreamConverter 将 Anthropic 流式事件转换为 OpenAI chunk
+type anthropicStreamConverter struct {
+	id        string
+	model     string
+	created   int64
+	usage     openAIUsage
+	toolIndex map[int]int // content block index -> tool_calls index
+}
+
+func newAnthropicStreamConverter(model string, created int64) *anthropicStreamConverter {
+	return &anthropicStreamConverter{
+		id:        newChatCompletionID(),
+		model:     model,
+		created:   created,
+		toolIndex: map[int]int{},
+	}
+}
+
+func (s *anthropicStreamConverter) chunk(delta *openAIResponseMessage, finishReason *string) *openAIChatResponse {
+	chunk := newOpenAIChunk(s.id, s.model, s.created)
+	chunk.Choices = []openAIChoice{{Index: 0, Delta: delta, FinishReason: finishReason}}
+	return chunk
+}
+
+// convert 处理一个 Anthropic 事件，返回需要发送给客户端的 chunk (可能为空)
+// 上游返回 error 事件时返回错误信息
+func (s *anthropicStreamConverter) convert(data string) ([]*openAIChatResponse, string) {
+	var ev anthropicStreamEvent
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		return nil, ""
+	}
+
+	switch ev.Type {
+	case "message_start":
+		if ev.Message != nil {
+			if ev.Message.ID != "" {
+				s.id = ev.Message.ID
+			}
+			s.usage.PromptTokens = ev.Message.Usage.promptTokens()
+			s.usage.PromptTokensDetails = cachedPromptTokens(ev.Message.Usage.CacheReadInputTokens)
+		}
+		return []*openAIChatResponse{s.chunk(&openAIResponseMessage{Role: "assistant", Content: stringPtr("")}, nil)}, ""
+
+	case "content_block_start":
+		if ev.ContentBlock != nil && ev.ContentBlock.Type == "tool_use" {
+			index := len(s.toolIndex)
+			s.toolIndex[ev.Index] = index
+			call := openAIToolCall{Index: &index, ID: ev.ContentBlock.ID, Type: "function"}
+			call.Function.Name = ev.ContentBlock.Name
+			return []*openAIChatResponse{s.chunk(&openAIResponseMessage{ToolCalls: []openAIToolCall{call}}, nil)}, ""
+		}
+
+	case "content_block_delta":
+		if ev.Delta == nil {
+			return nil, ""
+		}
+		switch ev.Delta.Type {
+		case "text_delta":
+			return []*openAIChatResponse{s.chunk(&openAIResponseMessage{Content: stringPtr(ev.Delta.Text)}, nil)}, ""
+		case "thinking_delta":
+			return []*openAIChatResponse{s.chunk(&openAIResponseMessage{ReasoningContent: ev.Delta.Thinking}, nil)}, ""
+		case "input_json_delta":
+			index, ok := s.toolIndex[ev.Index]
+			if !ok {
+				return nil, ""
+			}
+			call := openAIToolCall{Index: &index}
+			call.Function.Arguments = ev.Delta.PartialJSON
+			return []*openAIChatResponse{s.chunk(&openAIResponseMessage{ToolCalls: []openAIToolCall{call}}, nil)}, ""
+		}
+
+	case "message_delta":
+		if ev.Usage != nil {
+			s.usage.CompletionTokens = ev.Usage.OutputTokens
+			if ev.Usage.promptTokens() > 0 {
+				s.usage.PromptTokens = ev.Usage.promptTokens()
+				s.usage.PromptTokensDetails = cachedPromptTokens(ev.Usage.CacheReadInputTokens)
+			}
+		}
+		if ev.Delta != nil && ev.Delta.StopReason != "" {
+			finishReason := anthropicFinishReason(ev.Delta.StopReason)
+			return []*openAIChatResponse{s.chunk(&openAIResponseMessage{}, &finishReason)}, ""
+		}
+
+	case "error":
+		if ev.Error != nil {
+			return nil, ev.Error.Message
+		}
+		return nil, "upstream stream error"
+	}
+
+	return nil, ""
+}
+
+// usageChunk 流结束时的 usage chunk (choices 为空)
+func (s *anthropicStreamConverter) usageChunk() *openAIChatResponse {
+	chunk := newOpenAIChunk(s.id, s.model, s.created)
+	usage := s.usage
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	chunk.Usage = &usage
+	return chunk
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// OpenAI Chat Completions <-> Gemini generateContent 协议转换
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent         `json:"contents"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	ToolConfig        *geminiToolConfig       `json:"toolConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FileData         *geminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type geminiToolConfig struct {
+	FunctionCallingConfig struct {
+		Mode                 string   `json:"mode"`
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig"`
+}
+
+type geminiUsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+}
+
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *geminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ResponseID    string               `json:"responseId"`
+	Error         *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// geminiUnsupportedSchemaKeys Gemini 的 OpenAPI Schema 子集不接受的 JSON Schema 字段
+var geminiUnsupportedSchemaKeys = []string{"$schema", "additionalProperties", "$ref", "$defs", "definitions"}
+
+// imageMimeTypes 根据扩展名推断远程图片的 MIME 类型 (Gemini fileData 需要)
+var imageMimeTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+func geminiFinishReason(reason string, hasToolCalls bool) string {
+	switch reason {
+	case "":
+		return ""
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	}
+	if hasToolCalls {
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// toGeminiRequest 将 OpenAI 聊天请求转换为 Gemini generateContent 请求
+func toGeminiRequest(req *openAIChatRequest) *geminiRequest {
+	out := &geminiRequest{}
+
+	// tool 消息只携带 tool_call_id，需要从之前的 assistant 消息中找回函数名
+	toolNames := map[string]string{}
+	var system []geminiPart
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			if text := msg.textContent(); text != "" {
+				system = append(system, geminiPart{Text: text})
+			}
+		case "user":
+			out.appendContent("user", geminiPartsFromParts(msg.contentParts()))
+		case "assistant":
+			var parts []geminiPart
+			if text := msg.textContent(); text != "" {
+				parts = append(parts, geminiPart{Text: text})
+			}
+			for _, call := range msg.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+				args := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(args) {
+					args = json.RawMessage("{}")
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.Function.Name, Args: args}})
+			}
+			out.appendContent("model", parts)
+		case "tool":
+			name := toolNames[msg.ToolCallID]
+			if name == "" {
+				name = msg.Name
+			}
+			out.appendContent("user", []geminiPart{{FunctionResponse: &geminiFunctionResponse{
+				Name:     name,
+				Response: map[string]interface{}{"content": msg.textContent()},
+			}}})
+		}
+	}
+	if len(system) > 0 {
+		out.SystemInstruction = &geminiContent{Parts: system}
+	}
+
+	config := &geminiGenerationConfig{
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		MaxOutputTokens: req.maxOutputTokens(),
+		StopSequences:   req.stopSequences(),
+	}
+	if config.Temperature != nil || config.TopP != nil || config.MaxOutputTokens > 0 || len(config.StopSequences) > 0 {
+		out.GenerationConfig = config
+	}
+
+	if len(req.Tools) > 0 {
+		var decls []geminiFunctionDeclaration
+		for _, tool := range req.Tools {
+			decl := geminiFunctionDeclaration{Name: tool.Function.Name, Description: tool.Function.Description}
+			if len(tool.Function.Parameters) > 0 {
+				var schema interface{}
+				if err := json.Unmarshal(tool.Function.Parameters, &schema); err == nil {
+					decl.Parameters = cleanGeminiSchema(schema)
+				}
+			}
+			decls = append(decls, decl)
+		}
+		out.Tools = []geminiTool{{FunctionDeclarations: decls}}
+
+		mode, name := req.toolChoice()
+		toolConfig := &geminiToolConfig{}
+		switch mode {
+		case "auto":
+			toolConfig.FunctionCallingConfig.Mode = "AUTO"
+		case "required":
+			toolConfig.FunctionCallingConfig.Mode = "ANY"
+		case "none":
+			toolConfig.FunctionCallingConfig.Mode = "NONE"
+		case "function":
+			toolConfig.FunctionCallingConfig.Mode = "ANY"
+			toolConfig.FunctionCallingConfig.AllowedFunctionNames = []string{name}
+		}
+		if toolConfig.FunctionCallingConfig.Mode != "" {
+			out.ToolConfig = toolConfig
+		}
+	}
+
+	return out
+}
+
+// appendContent 追加对话内容，相同角色的相邻消息合并
+func (r *geminiRequest) appendContent(role string, parts []geminiPart) {
+	if len(parts) == 0 {
+		return
+	}
+	if n := len(r.Contents); n > 0 && r.Contents[n-1].Role == role {
+		r.Contents[n-1].Parts = append(r.Contents[n-1].Parts, parts...)
+		return
+	}
+	r.Contents = append(r.Contents, geminiContent{Role: role, Parts: parts})
+}
+
+// isGeminiFileURI Gemini 的 fileData.fileUri 只接受 Files API 上传的文件与 Cloud Storage (gs://) 地址
+func isGeminiFileURI(uri string) bool {
+	return strings.HasPrefix(uri, "gs://") || strings.HasPrefix(uri, geminiFilesURIPrefix)
+}
+
+// geminiFilesURIPrefix Files API 上传文件的 URI 前缀
+const geminiFilesURIPrefix = "https://generativelanguage.googleapis.com/v1beta/files/"
+
+// checkGeminiRequest 检查 OpenAI 聊天请求能否转换为 Gemini 请求，不能转换时返回描述性的错误信息
+// 图片只能是 data: URL 或 Gemini 可以读取的文件地址；tool 消息需要能找到对应的函数名
+func checkGeminiRequest(req *openAIChatRequest) string {
+	toolNames := map[string]string{}
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "user":
+			for _, part := range msg.contentParts() {
+				if part.Type != "image_url" || part.ImageURL == nil {
+					continue
+				}
+				if _, _, ok := parseDataURL(part.ImageURL.URL); !ok && !isGeminiFileURI(part.ImageURL.URL) {
+					return "Gemini models only accept images as base64 data: URLs, gs:// or Gemini Files API URIs"
+				}
+			}
+		case "assistant":
+			for _, call := range msg.ToolCalls {
+				toolNames[call.ID] = call.Function.Name
+			}
+		case "tool":
+			if toolNames[msg.ToolCallID] == "" && msg.Name == "" {
+				return "Tool message " + msg.ToolCallID + " does not match any previous assistant tool call"
+			}
+		}
+	}
+	return ""
+}
+
+// geminiPartsFromParts 将 OpenAI 内容分段转换为 Gemini parts
+// 远程图片地址需要先通过 checkGeminiRequest 检查
+func geminiPartsFromParts(parts []openAIContentPart) []geminiPart {
+	var out []geminiPart
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			if part.Text != "" {
+				out = append(out, geminiPart{Text: part.Text})
+			}
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			if mimeType, data, ok := parseDataURL(part.ImageURL.URL); ok {
+				out = append(out, geminiPart{InlineData: &geminiBlob{MimeType: mimeType, Data: data}})
+				continue
+			}
+			mimeType := imageMimeTypes[strings.ToLower(path.Ext(strings.SplitN(part.ImageURL.URL, "?", 2)[0]))]
+			if mimeType == "" {
+				mimeType = "image/jpeg"
+			}
+			out = append(out, geminiPart{FileData: &geminiFileData{MimeType: mimeType, FileURI: part.ImageURL.URL}})
+		}
+	}
+	return out
+}
+
+// cleanGeminiSchema 递归删除 Gemini 不支持的 JSON Schema 字段
+func cleanGeminiSchema(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for _, key := range geminiUnsupportedSchemaKeys {
+			delete(val, key)
+		}
+		for k, child := range val {
+			val[k] = cleanGeminiSchema(child)
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = cleanGeminiSchema(child)
+		}
+		return val
+	}
+	return v
+}
+
+// geminiCandidateMessage 提取第一个候选结果中的文本、思考内容与函数调用
+// callOffset 为已发出的工具调用数量，用于生成流式响应中连续的 index
+func geminiCandidateMessage(resp *geminiResponse, callOffset int) (text, reasoning string, calls []openAIToolCall, finishReason string) {
+	if len(resp.Candidates) == 0 {
+		return "", "", nil, ""
+	}
+	candidate := resp.Candidates[0]
+
+	var textBuf, reasoningBuf strings.Builder
+	for _, part := range candidate.Content.Parts {
+		switch {
+		case part.FunctionCall != nil:
+			index := callOffset + len(calls)
+			call := openAIToolCall{Index: &index, ID: fmt.Sprintf("call_%d", index), Type: "function"}
+			call.Function.Name = part.FunctionCall.Name
+			call.Function.Arguments = string(part.FunctionCall.Args)
+			if call.Function.Arguments == "" {
+				call.Function.Arguments = "{}"
+			}
+			calls = append(calls, call)
+		case part.Thought:
+			reasoningBuf.WriteString(part.Text)
+		default:
+			textBuf.WriteString(part.Text)
+		}
+	}
+
+	return textBuf.String(), reasoningBuf.String(), calls, candidate.FinishReason
+}
+
+func geminiUsage(meta *geminiUsageMetadata) *openAIUsage {
+	if meta == nil {
+		return nil
+	}
+	completion := meta.CandidatesTokenCount + meta.ThoughtsTokenCount
+	return &openAIUsage{
+		PromptTokens:        meta.PromptTokenCount,
+		CompletionTokens:    completion,
+		TotalTokens:         meta.PromptTokenCount + completion,
+		PromptTokensDetails: cachedPromptTokens(meta.CachedContentTokenCount),
+	}
+}
+
+// fromGeminiResponse 将 Gemini 非流式响应转换为 OpenAI 格式
+func fromGeminiResponse(resp *geminiResponse, model string) *openAIChatResponse {
+	text, reasoning, calls, reason := geminiCandidateMessage(resp, 0)
+	for i := range calls {
+		calls[i].Index = nil
+	}
+
+	out := newOpenAICompletion("", model)
+	finishReason := geminiFinishReason(reason, len(calls) > 0)
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	out.Choices = []openAIChoice{{
+		Index: 0,
+		Message: &openAIResponseMessage{
+			Role:             "assistant",
+			Content:          stringPtr(text),
+			ReasoningContent: reasoning,
+			ToolCalls:        calls,
+		},
+		FinishReason: &finishReason,
+	}}
+	out.Usage = geminiUsage(resp.UsageMetadata)
+	return out
+}
+
+// geminiStreamConverter 将 Gemini SSE 响应转换为 OpenAI chunk
+type geminiStreamConverter struct {
+	id        string
+	model     string
+	created   int64
+	started   bool
+	toolCalls int
+	usage     *openAIUsage
+}
+
+func newGeminiStreamConverter(model string, created int64) *geminiStreamConverter {
+	return &geminiStreamConverter{id: newChatCompletionID(), model: model, created: created}
+}
+
+// convert 处理一个 Gemini SSE 事件，返回需要发送给客户端的 chunk
+// 上游返回错误时返回错误信息
+func (s *geminiStreamConverter) convert(data string) ([]*openAIChatResponse, string) {
+	var resp geminiResponse
+	if err := json.Unmarshal([]byte(data), &resp); err != nil {
+		return nil, ""
+	}
+	if resp.Error != nil {
+		return nil, resp.Error.Message
+	}
+	if usage := geminiUsage(resp.UsageMetadata); usage != nil {
+		s.usage = usage
+	}
+
+	text, reasoning, calls, reason := geminiCandidateMessage(&resp, s.toolCalls)
+	s.toolCalls += len(calls)
+
+	var chunks []*openAIChatResponse
+	delta := &openAIResponseMessage{ReasoningContent: reasoning, ToolCalls: calls}
+	if text != "" {
+		delta.Content = stringPtr(text)
+	}
+	if !s.started {
+		s.started = true
+		delta.Role = "assistant"
+	}
+	if delta.Role != "" || delta.Content != nil || delta.ReasoningContent != "" || len(delta.ToolCalls) > 0 {
+		chunk := newOpenAIChunk(s.id, s.model, s.created)
+		chunk.Choices = []openAIChoice{{Index: 0, Delta: delta}}
+		chunks = append(chunks, chunk)
+	}
+
+	if finishReason := geminiFinishReason(reason, s.toolCalls > 0); finishReason != "" {
+		chunk := newOpenAIChunk(s.id, s.model, s.created)
+		chunk.Choices = []openAIChoice{{Index: 0, Delta: &openAIResponseMessage{}, FinishReason: &finishReason}}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, ""
+}
+
+// usageChunk 流结束时的 usage chunk (choices 为空)
+func (s *geminiStreamConverter) usageChunk() *openAIChatResponse {
+	chunk := newOpenAIChunk(s.id, s.model, s.created)
+	chunk.Usage = s.usage
+	if chunk.Usage == nil {
+		chunk.Usage = &openAIUsage{}
+	}
+	return chunk
+}
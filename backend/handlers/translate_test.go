@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func parseTestChatRequest(t *testing.T, body string) *openAIChatRequest {
+	t.Helper()
+	var req openAIChatRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("invalid test request: %v", err)
+	}
+	return &req
+}
+
+func TestToAnthropicRequestTemperature(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *float64
+	}{
+		{"unset", `{"messages":[]}`, nil},
+		{"in range", `{"temperature":0.7,"messages":[]}`, floatPtr(0.7)},
+		{"above Anthropic range", `{"temperature":1.5,"messages":[]}`, floatPtr(1)},
+		{"negative", `{"temperature":-0.5,"messages":[]}`, floatPtr(0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := parseTestChatRequest(t, tt.body)
+			var before float64
+			if req.Temperature != nil {
+				before = *req.Temperature
+			}
+
+			got := toAnthropicRequest(req, "claude", 0).Temperature
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("Temperature = %v, want %v", got, tt.want)
+			}
+			// 回退到其他 Provider 时仍使用原始请求，不能修改共享的值
+			if req.Temperature != nil && *req.Temperature != before {
+				t.Errorf("request temperature changed to %v", *req.Temperature)
+			}
+		})
+	}
+}
+
+func TestToAnthropicRequestMessages(t *testing.T) {
+	req := parseTestChatRequest(t, `{
+		"max_tokens": 256,
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "developer", "content": "use tools"},
+			{"role": "user", "content": [
+				{"type": "text", "text": "what is this?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBOR"}}
+			]},
+			{"role": "assistant", "content": "", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "not json"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "a cat"},
+			{"role": "user", "content": "thanks"}
+		],
+		"tools": [{"type": "function", "function": {"name": "lookup"}}],
+		"tool_choice": "required"
+	}`)
+
+	out := toAnthropicRequest(req, "claude", 0)
+	if out.MaxTokens != 256 {
+		t.Errorf("MaxTokens = %d, want 256", out.MaxTokens)
+	}
+	if out.System != "be brief\n\nuse tools" {
+		t.Errorf("System = %q", out.System)
+	}
+	if len(out.Messages) != 3 {
+		t.Fatalf("got %d messages, want user / assistant / user", len(out.Messages))
+	}
+	if src := out.Messages[0].Content[1].Source; src == nil || src.Type != "base64" || src.MediaType != "image/png" {
+		t.Errorf("image source = %+v, want base64 image/png", src)
+	}
+	if block := out.Messages[1].Content[0]; block.Type != "tool_use" || string(block.Input) != "{}" {
+		t.Errorf("tool_use block = %+v, want invalid arguments replaced with {}", block)
+	}
+	// tool 结果与随后的 user 消息合并为一条 user 消息
+	last := out.Messages[2]
+	if last.Role != "user" || len(last.Content) != 2 || last.Content[0].Type != "tool_result" || last.Content[0].ToolUseID != "call_1" {
+		t.Errorf("last message = %+v, want tool_result followed by text", last)
+	}
+	if string(out.Tools[0].InputSchema) != `{"type":"object","properties":{}}` {
+		t.Errorf("InputSchema = %s, want empty object schema", out.Tools[0].InputSchema)
+	}
+	if out.ToolChoice["type"] != "any" {
+		t.Errorf("ToolChoice = %v, want any", out.ToolChoice)
+	}
+}
+
+func TestToAnthropicRequestMaxTokensDefault(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		defaultMaxTokens int
+		want             int
+	}{
+		{"request value", `{"max_completion_tokens":100,"messages":[]}`, 2000, 100},
+		{"model default", `{"messages":[]}`, 2000, 2000},
+		{"fallback default", `{"messages":[]}`, 0, defaultAnthropicMaxTokens},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := toAnthropicRequest(parseTestChatRequest(t, tt.body), "claude", tt.defaultMaxTokens)
+			if out.MaxTokens != tt.want {
+				t.Errorf("MaxTokens = %d, want %d", out.MaxTokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromAnthropicResponse(t *testing.T) {
+	var resp anthropicResponse
+	json.Unmarshal([]byte(`{
+		"id": "msg_1",
+		"content": [
+			{"type": "thinking", "thinking": "hmm"},
+			{"type": "text", "text": "calling"},
+			{"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"q":"cat"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5, "cache_read_input_tokens": 20}
+	}`), &resp)
+
+	out := fromAnthropicResponse(&resp, "claude")
+	choice := out.Choices[0]
+	if *choice.FinishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q, want tool_calls", *choice.FinishReason)
+	}
+	if *choice.Message.Content != "calling" || choice.Message.ReasoningContent != "hmm" {
+		t.Errorf("message = %+v", choice.Message)
+	}
+	if calls := choice.Message.ToolCalls; len(calls) != 1 || calls[0].Function.Arguments != `{"q":"cat"}` {
+		t.Errorf("tool_calls = %+v", calls)
+	}
+	if out.Usage.PromptTokens != 30 || out.Usage.TotalTokens != 35 || out.Usage.PromptTokensDetails.CachedTokens != 20 {
+		t.Errorf("usage = %+v, want cached tokens counted in prompt_tokens", out.Usage)
+	}
+}
+
+func TestAnthropicStreamConverter(t *testing.T) {
+	conv := newAnthropicStreamConverter("claude", 0)
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":10}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"hi"}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"lookup"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"q\":"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+	}
+
+	var chunks []*openAIChatResponse
+	for _, ev := range events {
+		out, errMsg := conv.convert(ev)
+		if errMsg != "" {
+			t.Fatalf("convert(%s) error = %q", ev, errMsg)
+		}
+		chunks = append(chunks, out...)
+	}
+	if len(chunks) != 5 {
+		t.Fatalf("got %d chunks, want 5", len(chunks))
+	}
+	if chunks[0].ID != "msg_1" || chunks[0].Choices[0].Delta.Role != "assistant" {
+		t.Errorf("first chunk = %+v", chunks[0])
+	}
+	if call := chunks[3].Choices[0].Delta.ToolCalls[0]; *call.Index != 0 || call.Function.Arguments != `{"q":` {
+		t.Errorf("arguments delta = %+v, want index 0", call)
+	}
+	if reason := chunks[4].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("finish_reason = %v, want tool_calls", reason)
+	}
+	if usage := conv.usageChunk().Usage; usage.PromptTokens != 10 || usage.CompletionTokens != 7 || usage.TotalTokens != 17 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	if _, errMsg := conv.convert(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`); errMsg != "Overloaded" {
+		t.Errorf("error event message = %q, want Overloaded", errMsg)
+	}
+}
+
+func TestToGeminiRequest(t *testing.T) {
+	req := parseTestChatRequest(t, `{
+		"temperature": 1.5,
+		"max_tokens": 100,
+		"stop": "END",
+		"messages": [
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": [
+				{"type": "text", "text": "describe"},
+				{"type": "image_url", "image_url": {"url": "data:image/jpeg;base64,/9j/"}},
+				{"type": "image_url", "image_url": {"url": "gs://bucket/cat.png"}}
+			]},
+			{"role": "assistant", "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "lookup", "arguments": "{\"q\":\"cat\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "a cat"}
+		],
+		"tools": [{"type": "function", "function": {
+			"name": "lookup",
+			"parameters": {"$schema": "x", "type": "object", "additionalProperties": false,
+				"properties": {"q": {"type": "string", "additionalProperties": false}}}
+		}}],
+		"tool_choice": {"type": "function", "function": {"name": "lookup"}}
+	}`)
+
+	out := toGeminiRequest(req)
+	if out.SystemInstruction == nil || out.SystemInstruction.Parts[0].Text != "be brief" {
+		t.Errorf("systemInstruction = %+v", out.SystemInstruction)
+	}
+	if len(out.Contents) != 3 || out.Contents[1].Role != "model" {
+		t.Fatalf("contents = %+v, want user / model / user", out.Contents)
+	}
+
+	parts := out.Contents[0].Parts
+	if parts[1].InlineData == nil || parts[1].InlineData.MimeType != "image/jpeg" {
+		t.Errorf("data URL part = %+v, want inlineData", parts[1])
+	}
+	if parts[2].FileData == nil || parts[2].FileData.FileURI != "gs://bucket/cat.png" || parts[2].FileData.MimeType != "image/png" {
+		t.Errorf("file URI part = %+v, want fileData image/png", parts[2])
+	}
+	if resp := out.Contents[2].Parts[0].FunctionResponse; resp == nil || resp.Name != "lookup" {
+		t.Errorf("functionResponse = %+v, want name resolved from tool_call_id", resp)
+	}
+
+	// Gemini 的 temperature 范围为 0-2，原样传递
+	config := out.GenerationConfig
+	if config == nil || *config.Temperature != 1.5 || config.MaxOutputTokens != 100 || len(config.StopSequences) != 1 {
+		t.Errorf("generationConfig = %+v", config)
+	}
+
+	params, _ := json.Marshal(out.Tools[0].FunctionDeclarations[0].Parameters)
+	if want := `{"properties":{"q":{"type":"string"}},"type":"object"}`; string(params) != want {
+		t.Errorf("parameters = %s, want %s", params, want)
+	}
+	fc := out.ToolConfig.FunctionCallingConfig
+	if fc.Mode != "ANY" || len(fc.AllowedFunctionNames) != 1 || fc.AllowedFunctionNames[0] != "lookup" {
+		t.Errorf("functionCallingConfig = %+v", fc)
+	}
+}
+
+func TestCheckGeminiRequest(t *testing.T) {
+	image := func(url string) string {
+		return `{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"` + url + `"}}]}]}`
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"data URL image", image("data:image/png;base64,iVBOR"), false},
+		{"cloud storage image", image("gs://bucket/cat.png"), false},
+		{"files API image", image(geminiFilesURIPrefix + "abc123"), false},
+		{"remote http image", image("https://example.com/cat.png"), true},
+		{"tool result with matching call", `{"messages":[
+			{"role":"assistant","tool_calls":[{"id":"call_1","function":{"name":"lookup","arguments":"{}"}}]},
+			{"role":"tool","tool_call_id":"call_1","content":"ok"}]}`, false},
+		{"tool result with explicit name", `{"messages":[{"role":"tool","tool_call_id":"call_1","name":"lookup","content":"ok"}]}`, false},
+		{"tool result without a name", `{"messages":[{"role":"tool","tool_call_id":"call_9","content":"ok"}]}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if msg := checkGeminiRequest(parseTestChatRequest(t, tt.body)); (msg != "") != tt.wantErr {
+				t.Errorf("checkGeminiRequest() = %q, wantErr %v", msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFromGeminiResponse(t *testing.T) {
+	var resp geminiResponse
+	json.Unmarshal([]byte(`{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"text": "thinking", "thought": true},
+				{"text": "answer"},
+				{"functionCall": {"name": "lookup"}}
+			]},
+			"finishReason": "STOP"
+		}],
+		"usageMetadata": {"promptTokenCount": 10, "candidatesTokenCount": 5, "thoughtsTokenCount": 3}
+	}`), &resp)
+
+	out := fromGeminiResponse(&resp, "gemini")
+	msg := out.Choices[0].Message
+	if *msg.Content != "answer" || msg.ReasoningContent != "thinking" {
+		t.Errorf("message = %+v", msg)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Index != nil || msg.ToolCalls[0].Function.Arguments != "{}" {
+		t.Errorf("tool_calls = %+v, want one call without index and empty arguments", msg.ToolCalls)
+	}
+	if *out.Choices[0].FinishReason != "tool_calls" {
+		t.Errorf("finish_reason = %q, want tool_calls", *out.Choices[0].FinishReason)
+	}
+	if out.Usage.CompletionTokens != 8 || out.Usage.TotalTokens != 18 {
+		t.Errorf("usage = %+v, want thoughts counted as completion tokens", out.Usage)
+	}
+}
+
+func TestGeminiStreamConverter(t *testing.T) {
+	conv := newGeminiStreamConverter("gemini", 0)
+	events := []string{
+		`{"candidates":[{"content":{"parts":[{"text":"he"}]}}]}`,
+		`{"candidates":[{"content":{"parts":[{"functionCall":{"name":"a","args":{}}}]}}]}`,
+		`{"candidates":[{"content":{"parts":[{"functionCall":{"name":"b","args":{}}}]},"finishReason":"STOP"}],
+			"usageMetadata":{"promptTokenCount":4,"candidatesTokenCount":6}}`,
+	}
+
+	var chunks []*openAIChatResponse
+	for _, ev := range events {
+		out, errMsg := conv.convert(ev)
+		if errMsg != "" {
+			t.Fatalf("convert(%s) error = %q", ev, errMsg)
+		}
+		chunks = append(chunks, out...)
+	}
+	if len(chunks) != 4 {
+		t.Fatalf("got %d chunks, want 4", len(chunks))
+	}
+	if delta := chunks[0].Choices[0].Delta; delta.Role != "assistant" || *delta.Content != "he" {
+		t.Errorf("first delta = %+v", delta)
+	}
+	if call := chunks[2].Choices[0].Delta.ToolCalls[0]; *call.Index != 1 || call.ID != "call_1" {
+		t.Errorf("second tool call = %+v, want index 1 across chunks", call)
+	}
+	if reason := chunks[3].Choices[0].FinishReason; reason == nil || *reason != "tool_calls" {
+		t.Errorf("finish_reason = %v, want tool_calls", reason)
+	}
+	if usage := conv.usageChunk().Usage; usage.TotalTokens != 10 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	if _, errMsg := conv.convert(`{"error":{"code":429,"message":"Resource exhausted"}}`); errMsg != "Resource exhausted" {
+		t.Errorf("error message = %q, want Resource exhausted", errMsg)
+	}
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+
+	"chatbox-backend/middleware"
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// myRecentErrorsLimit /api/me/usage 返回的最近失败请求条数
+const myRecentErrorsLimit = 20
+
+// MyUsageResponse 当前用户的用量统计
+type MyUsageResponse struct {
+	From         string                      `json:"from"`
+	To           string                      `json:"to"`
+	Total        models.UsageAggregate       `json:"total"`
+	Days         []models.UsageAggregate     `json:"days"`        // 按天，时间升序
+	Models       []models.UsageAggregate     `json:"models"`      // 按模型 (providerId/modelId)，请求数多的在前
+	StatusCodes  map[string]map[string]int64 `json:"statusCodes"` // 模型 -> 错误状态码 -> 请求数
+	RecentErrors []models.UsageRecord        `json:"recentErrors"`
+}
+
+// GetMyUsage 获取当前用户自己的用量：按天与按模型的请求数、tokens 与错误数，以及最近失败的请求
+// 查询参数: from / to (YYYY-MM-DD，默认本月)、model、status
+func GetMyUsage(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	filter, ok := parseUsageFilter(c)
+	if !ok {
+		return
+	}
+	// 只能查看自己的用量
+	filter.UserID = user.ID
+	filter.Group = ""
+
+	days, err := models.AggregateUsage(models.UsageQuery{GroupBy: "day", Filter: filter})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+	byModel, err := models.AggregateUsage(models.UsageQuery{GroupBy: "model", Filter: filter, Sort: "requests"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+	counts, err := models.CountUsageErrors("model", filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+		return
+	}
+
+	recentErrors := []models.UsageRecord{}
+	if filter.Status != "success" {
+		errFilter := filter
+		if errFilter.Status == "" {
+			errFilter.Status = "error"
+		}
+		recentErrors, err = models.GetRecentUsageRecords(errFilter, myRecentErrorsLimit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage"})
+			return
+		}
+	}
+
+	total := models.UsageAggregate{Key: "total"}
+	for _, item := range days {
+		total.Add(item)
+	}
+
+	c.JSON(http.StatusOK, MyUsageResponse{
+		From:         filter.From.Format(usageDateLayout),
+		To:           filter.To.AddDate(0, 0, -1).Format(usageDateLayout),
+		Total:        total,
+		Days:         days,
+		Models:       byModel,
+		StatusCodes:  groupStatusCounts(counts),
+		RecentErrors: recentErrors,
+	})
+}
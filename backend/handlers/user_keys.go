@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"chatbox-backend/middleware"
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SaveMyProviderKeyRequest struct {
+	APIKey         string `json:"apiKey"`         // 为空时只更新 systemFallback
+	SystemFallback *bool  `json:"systemFallback"` // 个人 Key 失败时是否改用系统 Key，默认 true
+}
+
+// keyHint 返回 Key 的最后 4 位，Key 过短时不展示
+func keyHint(key string) string {
+	if len(key) <= 8 {
+		return ""
+	}
+	return key[len(key)-4:]
+}
+
+// customKeyProvider 解析路径中的 Provider ID 并检查是否允许自定义 Key，返回 nil 时已写入错误响应
+func customKeyProvider(c *gin.Context) *models.Provider {
+	id, err := strconv.ParseInt(c.Param("providerId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return nil
+	}
+
+	provider, err := models.GetProviderByID(id)
+	if err != nil || !provider.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return nil
+	}
+	if !provider.AllowCustomKey {
+		c.JSON(http.StatusForbidden, gin.H{"error": provider.Name + " does not allow custom API keys"})
+		return nil
+	}
+	return provider
+}
+
+// GetMyProviderKeys 获取当前用户保存的个人 Key (不返回 Key 本身)
+func GetMyProviderKeys(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	keys, err := models.GetUserProviderKeys(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get provider keys"})
+		return
+	}
+	if keys == nil {
+		keys = []models.UserProviderKey{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// SaveMyProviderKey 保存当前用户在指定 Provider 上的个人 Key，Key 加密后保存
+// 只有 allowCustomKey 为 true 的 Provider 可以保存个人 Key
+func SaveMyProviderKey(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	provider := customKeyProvider(c)
+	if provider == nil {
+		return
+	}
+
+	var req SaveMyProviderKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	req.APIKey = strings.TrimSpace(req.APIKey)
+
+	// 未提供 Key 时只更新已有个人 Key 的 systemFallback
+	if req.APIKey == "" {
+		if req.SystemFallback == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "apiKey is required"})
+			return
+		}
+		if _, err := models.GetUserProviderKey(user.ID, provider.ID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "apiKey is required"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save provider key"})
+			}
+			return
+		}
+		if err := models.UpdateUserProviderKeyFallback(user.ID, provider.ID, *req.SystemFallback); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save provider key"})
+			return
+		}
+		saved, err := models.GetUserProviderKey(user.ID, provider.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save provider key"})
+			return
+		}
+		c.JSON(http.StatusOK, saved)
+		return
+	}
+
+	if !checkAPIKeyInput(c, req.APIKey) {
+		return
+	}
+
+	systemFallback := true
+	if req.SystemFallback != nil {
+		systemFallback = *req.SystemFallback
+	}
+
+	saved, err := models.SaveUserProviderKey(&models.UserProviderKey{
+		UserID:         user.ID,
+		ProviderID:     provider.ID,
+		APIKey:         req.APIKey,
+		KeyHint:        keyHint(req.APIKey),
+		SystemFallback: systemFallback,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save provider key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, saved)
+}
+
+// DeleteMyProviderKey 删除当前用户在指定 Provider 上的个人 Key，之后改用系统 Key
+func DeleteMyProviderKey(c *gin.Context) {
+	user := middleware.GetCurrentUser(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("providerId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return
+	}
+
+	if err := models.DeleteUserProviderKey(user.ID, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete provider key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Provider key deleted"})
+}
@@ -1,18 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"chatbox-backend/config"
 	"chatbox-backend/database"
 	"chatbox-backend/handlers"
 	"chatbox-backend/middleware"
+	"chatbox-backend/models"
+	"chatbox-backend/secret"
+	"chatbox-backend/upstream"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+// shutdownTimeout 关闭服务器时等待进行中请求 (包括流式响应) 完成的时间
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	// 加载配置
 	cfg := config.Load()
@@ -40,14 +52,53 @@ func main() {
 		log.Fatalf("Failed to seed default admin: %v", err)
 	}
 
+	// 初始化上游 HTTP 客户端 (超时、出站代理、CA 证书)
+	if err := upstream.Init(cfg); err != nil {
+		log.Fatalf("Failed to initialize upstream client: %v", err)
+	}
+
+	// 初始化 API Key 加密
+	if err := secret.Init(cfg); err != nil {
+		log.Fatalf("Failed to initialize secret key: %v", err)
+	}
+
+	// 加密数据库中的明文 API Key，并用当前主密钥重新加密由旧密钥加密的 Key
+	// rotate-keys 子命令只执行这一步后退出
+	n, err := models.ReencryptSecrets()
+	if err != nil && !errors.Is(err, models.ErrUndecryptableSecrets) {
+		log.Fatalf("Failed to encrypt API keys after %d updates: %v", n, err)
+	}
+	if n > 0 {
+		log.Printf("Encrypted %d API key(s) with key %s", n, secret.ActiveKeyID())
+	}
+	rotateOnly := len(os.Args) > 1 && os.Args[1] == "rotate-keys"
+	if err != nil {
+		if rotateOnly {
+			log.Fatalf("Add the secret key they were encrypted with to SECRET_KEY: %v", err)
+		}
+		log.Printf("Add the secret key they were encrypted with to SECRET_KEY: %v", err)
+	}
+	if rotateOnly {
+		return
+	}
+
+	// 收到 SIGINT / SIGTERM 时停止后台任务并关闭服务器
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// 定时同步上游模型列表
+	handlers.StartModelSync(ctx, cfg.ModelSyncInterval)
+
 	// 设置 Gin
-	r := gin.Default()
+	r := gin.New()
+	r.Use(middleware.Logger(), gin.Recovery())
 
 	// CORS 配置
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "x-api-key", "anthropic-version", "anthropic-beta", "x-goog-api-key"},
+		ExposeHeaders:    []string{"X-Chatbox-Served-Model", "Retry-After", "x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests", "x-ratelimit-limit-tokens", "x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},
 		AllowCredentials: true,
 	}))
 
@@ -67,17 +118,39 @@ func main() {
 			auth.POST("/change-password", middleware.AuthRequired(cfg.JWTSecret), handlers.ChangePassword)
 		}
 
+		// 当前用户相关 (需要登录)
+		me := api.Group("/me")
+		me.Use(middleware.AuthRequired(cfg.JWTSecret))
+		{
+			me.GET("/usage", handlers.GetMyUsage)
+			me.GET("/provider-keys", handlers.GetMyProviderKeys)
+			me.PUT("/provider-keys/:providerId", handlers.SaveMyProviderKey)
+			me.DELETE("/provider-keys/:providerId", handlers.DeleteMyProviderKey)
+		}
+
 		// 配置相关 (公开)
 		configGroup := api.Group("/config")
 		{
 			configGroup.GET("/providers", handlers.GetPublicProviders)
 		}
 
-		// 代理相关 (公开，用于非管理员使用系统配置的 EnterAI)
+		// 代理相关 (需要登录，用于非管理员使用系统配置的 EnterAI)
 		proxy := api.Group("/proxy")
+		proxy.Use(middleware.ProxyAuthRequired(cfg.JWTSecret), middleware.RateLimit(cfg.RateLimitRPM, cfg.RateLimitTPM))
 		{
+			proxy.GET("/v1/models", handlers.ProxyListModels)
 			proxy.POST("/v1/chat/completions", handlers.ProxyChatCompletion)
 			proxy.POST("/v1/images/generations", handlers.ProxyImageGeneration)
+			proxy.POST("/v1/embeddings", handlers.ProxyEmbeddings)
+			proxy.POST("/v1/rerank", handlers.ProxyRerank)
+			proxy.POST("/anthropic/v1/messages", handlers.ProxyAnthropicMessages)
+		}
+
+		// Gemini 原生接口，登录 token 还可以通过查询参数 key 传递
+		gemini := api.Group("/proxy/google")
+		gemini.Use(middleware.GeminiAuthRequired(cfg.JWTSecret), middleware.RateLimit(cfg.RateLimitRPM, cfg.RateLimitTPM))
+		{
+			gemini.POST("/:version/models/:modelAction", handlers.ProxyGeminiGenerateContent)
 		}
 
 		// 管理员相关 (需要管理员权限)
@@ -86,15 +159,52 @@ func main() {
 		{
 			admin.GET("/providers", handlers.AdminGetProviders)
 			admin.POST("/providers", handlers.AdminCreateProvider)
+			admin.POST("/providers/test", handlers.AdminCheckProviderConfig)
 			admin.PUT("/providers/:id", handlers.AdminUpdateProvider)
 			admin.DELETE("/providers/:id", handlers.AdminDeleteProvider)
+			admin.DELETE("/providers/:id/api-key", handlers.AdminClearProviderAPIKey)
+			admin.POST("/providers/:id/test", handlers.AdminCheckProvider)
+			admin.GET("/providers/:id/models/discover", handlers.AdminDiscoverProviderModels)
+			admin.POST("/providers/:id/models/import", handlers.AdminImportProviderModels)
+			admin.GET("/providers/:id/keys", handlers.AdminGetProviderKeys)
+			admin.POST("/providers/:id/keys", handlers.AdminCreateProviderKey)
+			admin.PUT("/providers/:id/keys/:keyId", handlers.AdminUpdateProviderKey)
+			admin.DELETE("/providers/:id/keys/:keyId", handlers.AdminDeleteProviderKey)
+			admin.POST("/providers/:id/health/reset", handlers.AdminResetProviderHealth)
+			admin.GET("/upstream/health", handlers.AdminGetUpstreamHealth)
+			admin.GET("/rate-limits", handlers.AdminGetRateLimits)
+			admin.PUT("/rate-limits", handlers.AdminSaveRateLimit)
+			admin.DELETE("/rate-limits/:id", handlers.AdminDeleteRateLimit)
+			admin.GET("/quotas", handlers.AdminGetQuotas)
+			admin.PUT("/quotas", handlers.AdminSaveQuota)
+			admin.DELETE("/quotas/:id", handlers.AdminDeleteQuota)
+			admin.GET("/usage", handlers.AdminGetUsage)
+			admin.GET("/usage/top", handlers.AdminGetTopUsage)
+			admin.GET("/usage/errors", handlers.AdminGetUsageErrors)
+			admin.GET("/usage/costs", handlers.AdminGetUsageCosts)
+			admin.GET("/usage/export", handlers.AdminExportUsage)
 			admin.GET("/users", handlers.AdminGetUsers)
+			admin.PUT("/users/:id/group", handlers.AdminUpdateUserGroup)
 		}
 	}
 
 	// 启动服务器
+	srv := &http.Server{Addr: ":" + cfg.ServerPort, Handler: r}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Printf("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Server shutdown: %v", err)
+		}
+	}()
+
 	log.Printf("Server starting on port %s", cfg.ServerPort)
-	if err := r.Run(":" + cfg.ServerPort); err != nil {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	<-shutdownDone
 }
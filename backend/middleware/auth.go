@@ -17,26 +17,57 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
-// AuthRequired JWT 认证中间件
+// AuthRequired JWT 认证中间件，登录 token 通过 Authorization: Bearer 传递
 func AuthRequired(jwtSecret string) gin.HandlerFunc {
+	return authRequired(jwtSecret)
+}
+
+// ProxyAuthRequired 代理接口的认证中间件
+// Anthropic / Gemini SDK 通过 x-api-key / x-goog-api-key 传递凭证，代理接口中该值即为登录 token
+func ProxyAuthRequired(jwtSecret string) gin.HandlerFunc {
+	return authRequired(jwtSecret, "x-api-key", "x-goog-api-key")
+}
+
+// GeminiAuthRequired Gemini 原生接口的认证中间件，在 ProxyAuthRequired 的基础上接受查询参数 key
+// (Gemini SDK 与 REST 示例的默认方式)
+func GeminiAuthRequired(jwtSecret string) gin.HandlerFunc {
+	auth := authRequired(jwtSecret, "x-api-key", "x-goog-api-key")
+	return func(c *gin.Context) {
+		if key := c.Query("key"); key != "" && c.GetHeader("Authorization") == "" && c.GetHeader("x-goog-api-key") == "" {
+			c.Request.Header.Set("x-goog-api-key", key)
+		}
+		auth(c)
+	}
+}
+
+// authRequired 校验 Authorization: Bearer 或 apiKeyHeaders 中第一个非空请求头中的登录 token
+func authRequired(jwtSecret string, apiKeyHeaders ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
+		var apiKeyHeader string
+		for _, h := range apiKeyHeaders {
+			if apiKeyHeader = c.GetHeader(h); apiKeyHeader != "" {
+				break
+			}
+		}
+		if authHeader == "" && apiKeyHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
 			c.Abort()
 			return
 		}
 
-		// 解析 Bearer token
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-			c.Abort()
-			return
+		tokenString := apiKeyHeader
+		if authHeader != "" {
+			// 解析 Bearer token
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+				c.Abort()
+				return
+			}
+			tokenString = parts[1]
 		}
 
-		tokenString := parts[1]
-
 		// 验证 token
 		claims := &Claims{}
 		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
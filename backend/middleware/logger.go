@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Logger 请求日志中间件，格式与 gin 默认日志相同 (不带颜色)
+// Gemini 接口的登录 token 可能通过查询参数 key 传递，写入日志前隐藏
+func Logger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(p gin.LogFormatterParams) string {
+		if p.Latency > time.Minute {
+			p.Latency = p.Latency.Truncate(time.Second)
+		}
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			p.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			p.StatusCode,
+			p.Latency,
+			p.ClientIP,
+			p.Method,
+			redactQueryKey(p.Path),
+			p.ErrorMessage,
+		)
+	})
+}
+
+// redactQueryKey 隐藏路径中查询参数 key 的值
+func redactQueryKey(path string) string {
+	i := strings.IndexByte(path, '?')
+	if i < 0 {
+		return path
+	}
+	query, err := url.ParseQuery(path[i+1:])
+	if err != nil || !query.Has("key") {
+		return path
+	}
+	query.Set("key", "REDACTED")
+	return path[:i+1] + query.Encode()
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quotaPeriodNames 配额周期在错误信息中的名称
+var quotaPeriodNames = map[string]string{
+	models.QuotaPeriodDay:   "daily",
+	models.QuotaPeriodMonth: "monthly",
+}
+
+// ExceededQuota 返回用户本人或所在分组在当前周期已用尽的配额，没有用尽时返回 nil，周期结束后自动恢复
+// 配额只统计系统 Key 的用量，由代理在解析出目标后检查：使用个人 Key 的请求不受配额限制
+// 配额查询失败时放行，避免数据库问题导致代理整体不可用
+func ExceededQuota(user *models.User) *models.QuotaStatus {
+	if user == nil {
+		return nil
+	}
+
+	statuses, err := models.GetUserQuotaStatuses(user)
+	if err != nil {
+		log.Printf("[Quota] failed to check quotas for user %d: %v", user.ID, err)
+		return nil
+	}
+
+	for i := range statuses {
+		if statuses[i].Exceeded {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+// QuotaExceededMessage 设置 Retry-After 并返回配额用尽的错误信息，由调用方按自己的协议格式写入 429 响应
+func QuotaExceededMessage(c *gin.Context, st *models.QuotaStatus) string {
+	owner := "your account"
+	if st.Scope == models.QuotaScopeGroup {
+		owner = "group " + st.Target
+	}
+	c.Header("Retry-After", strconv.Itoa(int(time.Until(st.ResetsAt).Seconds())+1))
+	return fmt.Sprintf("Quota exceeded: the %s quota for %s has been used up, it resets at %s",
+		quotaPeriodNames[st.Period], owner, st.ResetsAt.Format(time.RFC3339))
+}
@@ -0,0 +1,258 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"chatbox-backend/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenBucket 令牌桶，容量为每分钟限额，按容量/分钟的速度匀速恢复
+type tokenBucket struct {
+	capacity float64
+	tokens   float64
+	updated  time.Time
+}
+
+func newTokenBucket(limit int, now time.Time) *tokenBucket {
+	return &tokenBucket{capacity: float64(limit), tokens: float64(limit), updated: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	rate := b.capacity / time.Minute.Seconds()
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+}
+
+// wait 取出 n 个令牌还需要等待的时间，n 超过容量时按容量计算
+func (b *tokenBucket) wait(n float64) time.Duration {
+	n = math.Min(n, b.capacity)
+	if b.tokens >= n {
+		return 0
+	}
+	rate := b.capacity / time.Minute.Seconds()
+	return time.Duration((n - b.tokens) / rate * float64(time.Second))
+}
+
+// settle 按实际用量修正预扣的 charged 个令牌
+// 实际用量超出预估时允许欠账 (最多一个容量)，后续请求需要等待恢复
+func (b *tokenBucket) settle(charged, actual float64, now time.Time) {
+	b.refill(now)
+	b.tokens = math.Max(-b.capacity, math.Min(b.capacity, b.tokens+charged-actual))
+}
+
+// resetIn 令牌桶恢复到满的时间
+func (b *tokenBucket) resetIn() time.Duration {
+	rate := b.capacity / time.Minute.Seconds()
+	return time.Duration((b.capacity - b.tokens) / rate * float64(time.Second))
+}
+
+type userBuckets struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+	lastSeen time.Time
+}
+
+// bucketIdleTTL 令牌桶最多两分钟恢复满额 (tokens 最低为 -容量)，空闲更久的用户删除后重建的结果相同
+const bucketIdleTTL = 2 * time.Minute
+
+var (
+	bucketMu sync.Mutex
+	// userID -> 令牌桶
+	buckets   = map[int64]*userBuckets{}
+	lastSweep time.Time
+)
+
+// sweepIdleBuckets 删除空闲超过 bucketIdleTTL 的令牌桶，每 bucketIdleTTL 最多执行一次，调用方需持有 bucketMu
+func sweepIdleBuckets(now time.Time) {
+	if now.Sub(lastSweep) < bucketIdleTTL {
+		return
+	}
+	for id, ub := range buckets {
+		if now.Sub(ub.lastSeen) >= bucketIdleTTL {
+			delete(buckets, id)
+		}
+	}
+	lastSweep = now
+}
+
+// actualTokensKey 请求 context 中保存实际消耗 tokens 的键
+const actualTokensKey = "rateLimitActualTokens"
+
+// SetActualTokens 记录请求实际消耗的 tokens (上游返回的 usage)，RateLimit 在请求结束后据此修正预估的扣减
+func SetActualTokens(c *gin.Context, tokens int) {
+	c.Set(actualTokensKey, tokens)
+}
+
+// bucketFor 返回与当前限额一致的令牌桶，限额为 0 时返回 nil；限额变更后重建令牌桶
+func bucketFor(b **tokenBucket, limit int, now time.Time) *tokenBucket {
+	if limit <= 0 {
+		*b = nil
+		return nil
+	}
+	if *b == nil || (*b).capacity != float64(limit) {
+		*b = newTokenBucket(limit, now)
+	}
+	(*b).refill(now)
+	return *b
+}
+
+// resolveRateLimits 计算用户生效的每分钟请求数与 tokens 限额，用户配置 > 角色配置 > 全局默认值
+func resolveRateLimits(user *models.User, defaultRPM, defaultTPM int) (rpm, tpm int) {
+	rpm, tpm = defaultRPM, defaultTPM
+
+	roleLimit, userLimit, err := models.GetUserRateLimits(user.ID, user.Role)
+	if err != nil {
+		log.Printf("[RateLimit] failed to load limits for user %d: %v", user.ID, err)
+		return rpm, tpm
+	}
+	for _, l := range []*models.RateLimit{roleLimit, userLimit} {
+		if l == nil {
+			continue
+		}
+		if l.RequestsPerMinute != nil {
+			rpm = *l.RequestsPerMinute
+		}
+		if l.TokensPerMinute != nil {
+			tpm = *l.TokensPerMinute
+		}
+	}
+	return rpm, tpm
+}
+
+// EstimateRequestTokens 粗略估算请求消耗的 tokens：请求体按 4 字节一个 token 估算，加上请求的最大输出 tokens
+// 兼容 OpenAI (max_tokens / max_completion_tokens)、Anthropic (max_tokens) 与 Gemini (generationConfig.maxOutputTokens)
+func EstimateRequestTokens(body []byte) int {
+	estimate := len(body) / 4
+
+	var req struct {
+		MaxTokens           int `json:"max_tokens"`
+		MaxCompletionTokens int `json:"max_completion_tokens"`
+		GenerationConfig    struct {
+			MaxOutputTokens int `json:"maxOutputTokens"`
+		} `json:"generationConfig"`
+	}
+	if err := json.Unmarshal(body, &req); err == nil {
+		switch {
+		case req.MaxCompletionTokens > 0:
+			estimate += req.MaxCompletionTokens
+		case req.MaxTokens > 0:
+			estimate += req.MaxTokens
+		default:
+			estimate += req.GenerationConfig.MaxOutputTokens
+		}
+	}
+	return estimate
+}
+
+// RateLimit 代理接口限流中间件，需在 AuthRequired 之后使用
+// 按用户维护请求数与 tokens 两个令牌桶，超出限额时返回 429、Retry-After 与 x-ratelimit-* 响应头
+// tokens 先按 EstimateRequestTokens 预扣，处理器通过 SetActualTokens 报告实际用量后按差额修正
+// GET 请求 (列出模型) 不访问上游，不受限流
+func RateLimit(defaultRPM, defaultTPM int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetCurrentUser(c)
+		if user == nil || c.Request.Method == http.MethodGet {
+			c.Next()
+			return
+		}
+
+		rpm, tpm := resolveRateLimits(user, defaultRPM, defaultTPM)
+		if rpm <= 0 && tpm <= 0 {
+			c.Next()
+			return
+		}
+
+		// tokens 限额需要读取请求体估算，读取后放回供后续处理
+		var cost float64
+		if tpm > 0 && c.Request.Body != nil {
+			body, err := io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+				c.Abort()
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+			cost = float64(EstimateRequestTokens(body))
+		}
+
+		now := time.Now()
+		bucketMu.Lock()
+		sweepIdleBuckets(now)
+		ub, ok := buckets[user.ID]
+		if !ok {
+			ub = &userBuckets{}
+			buckets[user.ID] = ub
+		}
+		ub.lastSeen = now
+		reqBucket := bucketFor(&ub.requests, rpm, now)
+		tokBucket := bucketFor(&ub.tokens, tpm, now)
+
+		var retryAfter time.Duration
+		if reqBucket != nil {
+			retryAfter = reqBucket.wait(1)
+		}
+		if tokBucket != nil {
+			if w := tokBucket.wait(cost); w > retryAfter {
+				retryAfter = w
+			}
+		}
+		var charged float64 // 实际从 tokens 令牌桶扣除的数量
+		if retryAfter == 0 {
+			if reqBucket != nil {
+				reqBucket.tokens--
+			}
+			if tokBucket != nil {
+				charged = math.Min(cost, math.Max(0, tokBucket.tokens))
+				tokBucket.tokens -= charged
+			}
+		}
+
+		if reqBucket != nil {
+			setRateLimitHeaders(c, "requests", rpm, reqBucket)
+		}
+		if tokBucket != nil {
+			setRateLimitHeaders(c, "tokens", tpm, tokBucket)
+		}
+		bucketMu.Unlock()
+
+		if retryAfter > 0 {
+			secs := strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+			c.Header("Retry-After", secs)
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded, please retry after " + secs + "s"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+
+		if v, ok := c.Get(actualTokensKey); ok && tokBucket != nil {
+			actual, _ := v.(int)
+			now := time.Now()
+			bucketMu.Lock()
+			// 长时间的流式请求期间令牌桶可能已被清理，放回以保留修正结果
+			if _, ok := buckets[user.ID]; !ok {
+				buckets[user.ID] = ub
+			}
+			ub.lastSeen = now
+			tokBucket.settle(charged, float64(actual), now)
+			bucketMu.Unlock()
+		}
+	}
+}
+
+// setRateLimitHeaders 写入 OpenAI 风格的 x-ratelimit-* 响应头
+func setRateLimitHeaders(c *gin.Context, kind string, limit int, b *tokenBucket) {
+	c.Header("x-ratelimit-limit-"+kind, strconv.Itoa(limit))
+	c.Header("x-ratelimit-remaining-"+kind, strconv.Itoa(int(math.Max(0, b.tokens))))
+	c.Header("x-ratelimit-reset-"+kind, b.resetIn().Round(time.Millisecond).String())
+}
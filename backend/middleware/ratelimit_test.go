@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		limit  int
+		tokens float64
+		n      float64
+		want   time.Duration
+	}{
+		{"enough tokens", 60, 10, 1, 0},
+		{"one token short", 60, 0, 1, time.Second},
+		{"partially refilled", 60, 0.5, 1, 500 * time.Millisecond},
+		{"cost above capacity waits for a full bucket", 60, 0, 1000, time.Minute},
+		{"debt waits until the bucket recovers", 60, -60, 60, 2 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.limit, now)
+			b.tokens = tt.tokens
+			if got := b.wait(tt.n); got != tt.want {
+				t.Errorf("wait(%v) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(60, now)
+	b.tokens = 0
+
+	b.refill(now.Add(30 * time.Second))
+	if b.tokens != 30 {
+		t.Errorf("tokens after 30s = %v, want 30", b.tokens)
+	}
+	b.refill(now.Add(5 * time.Minute))
+	if b.tokens != 60 {
+		t.Errorf("tokens after 5m = %v, want capped at 60", b.tokens)
+	}
+}
+
+func TestTokenBucketSettle(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		tokens  float64
+		charged float64
+		actual  float64
+		want    float64
+	}{
+		{"actual below estimate refunds the difference", 500, 400, 100, 800},
+		{"actual above estimate charges the difference", 500, 100, 400, 200},
+		{"refund is capped at capacity", 900, 400, 0, 1000},
+		{"debt is capped at one capacity", 0, 0, 5000, -1000},
+		{"uncharged request is charged in full", 0, 0, 300, -300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(1000, now)
+			b.tokens = tt.tokens
+			b.settle(tt.charged, tt.actual, now)
+			if b.tokens != tt.want {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketForRebuildsOnLimitChange(t *testing.T) {
+	now := time.Now()
+	var b *tokenBucket
+
+	first := bucketFor(&b, 60, now)
+	first.tokens = 0
+	if got := bucketFor(&b, 60, now); got != first {
+		t.Fatal("bucketFor() rebuilt the bucket although the limit did not change")
+	}
+	if got := bucketFor(&b, 120, now); got == first || got.tokens != 120 {
+		t.Fatalf("bucketFor() after limit change = %+v, want a new full bucket", got)
+	}
+	if got := bucketFor(&b, 0, now); got != nil || b != nil {
+		t.Fatal("bucketFor() with limit 0 should drop the bucket")
+	}
+}
+
+func TestSweepIdleBuckets(t *testing.T) {
+	now := time.Now()
+	bucketMu.Lock()
+	defer bucketMu.Unlock()
+	defer func() {
+		buckets = map[int64]*userBuckets{}
+		lastSweep = time.Time{}
+	}()
+
+	buckets = map[int64]*userBuckets{
+		1: {lastSeen: now.Add(-bucketIdleTTL)},
+		2: {lastSeen: now.Add(-time.Second)},
+	}
+	lastSweep = now.Add(-time.Second)
+
+	sweepIdleBuckets(now)
+	if len(buckets) != 2 {
+		t.Fatalf("sweep ran again within bucketIdleTTL, %d buckets left", len(buckets))
+	}
+
+	lastSweep = now.Add(-bucketIdleTTL)
+	sweepIdleBuckets(now)
+	if _, ok := buckets[1]; ok {
+		t.Error("idle bucket was not removed")
+	}
+	if _, ok := buckets[2]; !ok {
+		t.Error("active bucket was removed")
+	}
+}
+
+func TestEstimateRequestTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"openai max_tokens", `{"max_tokens":100}`, 4 + 100},
+		{"max_completion_tokens takes precedence", `{"max_tokens":100,"max_completion_tokens":200}`, 11 + 200},
+		{"gemini maxOutputTokens", `{"generationConfig":{"maxOutputTokens":50}}`, 10 + 50},
+		{"invalid JSON counts the body only", `not json`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EstimateRequestTokens([]byte(tt.body)); got != tt.want {
+				t.Errorf("EstimateRequestTokens(%s) = %d, want %d", tt.body, got, tt.want)
+			}
+		})
+	}
+}
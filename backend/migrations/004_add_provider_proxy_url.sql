@@ -0,0 +1,4 @@
+-- 迁移: 004_add_provider_proxy_url
+-- 说明: Provider 级别的出站代理 (http/https/socks5)，为空时使用全局 UPSTREAM_PROXY
+
+ALTER TABLE system_providers ADD COLUMN proxy_url VARCHAR(255) NOT NULL DEFAULT '' AFTER api_key;
@@ -0,0 +1,17 @@
+-- 迁移: 005_create_provider_api_keys
+-- 说明: Provider 的 API Key 池，代理按权重轮询，失败时切换到下一个 Key
+-- system_providers.api_key 仍作为默认 Key 参与轮询
+
+CREATE TABLE IF NOT EXISTS provider_api_keys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    provider_id BIGINT NOT NULL,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    api_key VARCHAR(500) NOT NULL,
+    weight INT NOT NULL DEFAULT 1,
+    enabled TINYINT(1) NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    CONSTRAINT fk_provider_api_keys_provider FOREIGN KEY (provider_id) REFERENCES system_providers(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE INDEX idx_provider_api_keys_provider ON provider_api_keys(provider_id, enabled);
@@ -0,0 +1,14 @@
+-- 迁移: 006_create_rate_limits
+-- 说明: 代理接口的限流配置，按角色 (users.role) 或单个用户覆盖全局默认值
+-- 字段为 NULL 时沿用上一级配置，0 表示不限制
+
+CREATE TABLE IF NOT EXISTS rate_limits (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL,
+    target VARCHAR(100) NOT NULL,
+    requests_per_minute INT NULL,
+    tokens_per_minute INT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_rate_limits_scope_target (scope, target)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
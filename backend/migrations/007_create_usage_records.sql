@@ -0,0 +1,23 @@
+-- 迁移: 007_create_usage_records
+-- 说明: 代理请求的 tokens 用量记录，每个请求一条
+-- provider 保存 Provider ID 字符串，Provider 删除后记录仍然保留
+
+CREATE TABLE IF NOT EXISTS usage_records (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    provider_id BIGINT NOT NULL DEFAULT 0,
+    provider VARCHAR(50) NOT NULL DEFAULT '',
+    model VARCHAR(100) NOT NULL DEFAULT '',
+    endpoint VARCHAR(50) NOT NULL DEFAULT '',
+    prompt_tokens INT NOT NULL DEFAULT 0,
+    completion_tokens INT NOT NULL DEFAULT 0,
+    total_tokens INT NOT NULL DEFAULT 0,
+    latency_ms INT NOT NULL DEFAULT 0,
+    status_code INT NOT NULL DEFAULT 0,
+    stream TINYINT(1) NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE INDEX idx_usage_records_user_created ON usage_records(user_id, created_at);
+CREATE INDEX idx_usage_records_created ON usage_records(created_at);
+CREATE INDEX idx_usage_records_provider_model ON usage_records(provider, model);
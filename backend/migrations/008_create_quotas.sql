@@ -0,0 +1,21 @@
+-- 迁移: 008_create_quotas
+-- 说明: 用户分组与 tokens / 费用配额
+-- 分组配额由组内所有用户共享，用户配额只统计本人用量；按自然日 / 自然月重置
+
+ALTER TABLE users ADD COLUMN user_group VARCHAR(50) NOT NULL DEFAULT '' AFTER role;
+CREATE INDEX idx_users_user_group ON users(user_group);
+
+-- 请求费用，配置模型价格后计算
+ALTER TABLE usage_records ADD COLUMN cost DECIMAL(20,8) NOT NULL DEFAULT 0 AFTER total_tokens;
+
+CREATE TABLE IF NOT EXISTS quotas (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL,
+    target VARCHAR(100) NOT NULL,
+    period VARCHAR(20) NOT NULL,
+    token_limit BIGINT NULL,
+    cost_limit DECIMAL(20,6) NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_quotas_scope_target_period (scope, target, period)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
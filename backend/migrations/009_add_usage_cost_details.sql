@@ -0,0 +1,5 @@
+-- 迁移: 009_add_usage_cost_details
+-- 说明: 用量记录增加缓存命中的输入 tokens 与生成图片数量，用于按模型价格计算费用
+
+ALTER TABLE usage_records ADD COLUMN cached_tokens INT NOT NULL DEFAULT 0 AFTER completion_tokens;
+ALTER TABLE usage_records ADD COLUMN image_count INT NOT NULL DEFAULT 0 AFTER total_tokens;
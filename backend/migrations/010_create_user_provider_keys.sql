@@ -0,0 +1,19 @@
+-- 迁移: 010_create_user_provider_keys
+-- 说明: 用户为允许自定义 Key 的 Provider 保存的个人 API Key (加密保存)，每个用户每个 Provider 一个
+-- 用量记录增加 key_source，区分使用系统 Key 与个人 Key 的请求
+
+CREATE TABLE IF NOT EXISTS user_provider_keys (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    provider_id BIGINT NOT NULL,
+    api_key VARCHAR(1000) NOT NULL,
+    key_hint VARCHAR(10) NOT NULL DEFAULT '',
+    system_fallback TINYINT(1) NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_user_provider_keys (user_id, provider_id),
+    CONSTRAINT fk_user_provider_keys_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_user_provider_keys_provider FOREIGN KEY (provider_id) REFERENCES system_providers(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE usage_records ADD COLUMN key_source VARCHAR(10) NOT NULL DEFAULT 'system' AFTER endpoint;
@@ -0,0 +1,6 @@
+-- 迁移: 011_widen_api_key_columns
+-- 说明: API Key 改为 AES-GCM 加密保存，密文比明文长，扩大列长度
+-- 已有的明文 Key 仍可使用，服务启动时会自动加密 (也可单独执行 `chatbox-backend rotate-keys`)
+
+ALTER TABLE system_providers MODIFY COLUMN api_key VARCHAR(1000);
+ALTER TABLE provider_api_keys MODIFY COLUMN api_key VARCHAR(1000) NOT NULL;
@@ -0,0 +1,11 @@
+-- 迁移: 012_add_api_key_masks
+-- 说明: 保存 API Key 的脱敏信息 (最后 4 位、指纹、最近设置时间)，管理员接口只返回这些信息
+-- 已有 Key 的脱敏信息在服务启动时补全 (也可单独执行 `chatbox-backend rotate-keys`)
+
+ALTER TABLE system_providers ADD COLUMN api_key_last4 VARCHAR(4) NOT NULL DEFAULT '' AFTER api_key;
+ALTER TABLE system_providers ADD COLUMN api_key_fingerprint VARCHAR(16) NOT NULL DEFAULT '' AFTER api_key_last4;
+ALTER TABLE system_providers ADD COLUMN api_key_rotated_at TIMESTAMP NULL AFTER api_key_fingerprint;
+
+ALTER TABLE provider_api_keys ADD COLUMN api_key_last4 VARCHAR(4) NOT NULL DEFAULT '' AFTER api_key;
+ALTER TABLE provider_api_keys ADD COLUMN api_key_fingerprint VARCHAR(16) NOT NULL DEFAULT '' AFTER api_key_last4;
+ALTER TABLE provider_api_keys ADD COLUMN api_key_rotated_at TIMESTAMP NULL AFTER api_key_fingerprint;
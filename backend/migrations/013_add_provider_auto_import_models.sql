@@ -0,0 +1,4 @@
+-- 迁移: 013_add_provider_auto_import_models
+-- 说明: 定时同步上游模型列表时，是否自动导入该 Provider 新增的模型
+
+ALTER TABLE system_providers ADD COLUMN auto_import_models TINYINT(1) NOT NULL DEFAULT 0 AFTER models;
@@ -0,0 +1,4 @@
+-- 迁移: 014_add_usage_error_message
+-- 说明: 用量记录增加错误信息，记录流式响应开始后上游返回的错误或中断
+
+ALTER TABLE usage_records ADD COLUMN error_message VARCHAR(500) NOT NULL DEFAULT '' AFTER status_code;
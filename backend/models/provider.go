@@ -9,28 +9,61 @@ import (
 type ProviderModel struct {
 	ModelID       string   `json:"modelId"`
 	Nickname      string   `json:"nickname,omitempty"`
-	Type          string   `json:"type,omitempty"`          // chat | embedding | rerank
-	APIStyle      string   `json:"apiStyle,omitempty"`      // openai | google | anthropic
+	Type          string   `json:"type,omitempty"`     // chat | embedding | rerank
+	APIStyle      string   `json:"apiStyle,omitempty"` // openai | google | anthropic
 	Labels        []string `json:"labels,omitempty"`
-	Capabilities  []string `json:"capabilities,omitempty"`  // vision | reasoning | tool_use | web_search
 	ContextWindow int      `json:"contextWindow,omitempty"` // 上下文窗口大小
 	MaxOutput     int      `json:"maxOutput,omitempty"`     // 最大输出 tokens
+	Fallbacks     []string `json:"fallbacks,omitempty"`     // 备用模型链，"modelId" 或 "providerId/modelId"，按顺序尝试
+
+	// Capabilities 模型支持的能力: vision | reasoning | tool_use | web_search
+	// 非空时视为完整列表，代理拒绝列表中没有 vision 的图片请求与没有 tool_use 的工具请求；
+	// 为空表示能力未知，不做检查。只应由管理员填写，推断的能力放在 SuggestedCapabilities
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// SuggestedCapabilities 导入上游模型时按模型 ID 推断的能力，可能不完整，仅供管理员参考，不参与请求检查
+	SuggestedCapabilities []string `json:"suggestedCapabilities,omitempty"`
+
+	// 价格，tokens 按每百万计价，与配额的 costLimit 使用同一币种
+	InputPrice       float64 `json:"inputPrice,omitempty"`
+	OutputPrice      float64 `json:"outputPrice,omitempty"`
+	CachedInputPrice float64 `json:"cachedInputPrice,omitempty"` // 缓存命中的输入价格，未配置时按 InputPrice 计算
+	ImagePrice       float64 `json:"imagePrice,omitempty"`       // 每张图片的价格 (图片模型)
+}
+
+// Cost 按模型价格计算一次请求的费用，promptTokens 包含缓存命中的 cachedTokens
+func (m *ProviderModel) Cost(promptTokens, cachedTokens, completionTokens, images int) float64 {
+	cachedPrice := m.CachedInputPrice
+	if cachedPrice == 0 {
+		cachedPrice = m.InputPrice
+	}
+	if cachedTokens > promptTokens {
+		cachedTokens = promptTokens
+	}
+
+	cost := float64(promptTokens-cachedTokens)*m.InputPrice +
+		float64(cachedTokens)*cachedPrice +
+		float64(completionTokens)*m.OutputPrice
+	return cost/1e6 + float64(images)*m.ImagePrice
 }
 
 type Provider struct {
-	ID             int64           `json:"id"`
-	ProviderID     string          `json:"providerId"`
-	Name           string          `json:"name"`
-	APIStyle       string          `json:"apiStyle"`
-	APIHost        string          `json:"apiHost,omitempty"`
-	APIKey         string          `json:"apiKey,omitempty"`
-	Enabled        bool            `json:"enabled"`
-	AllowCustomKey bool            `json:"allowCustomKey"`
-	Models         []ProviderModel `json:"models,omitempty"`
-	IsDefault      bool            `json:"isDefault"`
-	SortOrder      int             `json:"sortOrder"`
-	CreatedAt      time.Time       `json:"createdAt"`
-	UpdatedAt      time.Time       `json:"updatedAt"`
+	ID               int64           `json:"id"`
+	ProviderID       string          `json:"providerId"`
+	Name             string          `json:"name"`
+	APIStyle         string          `json:"apiStyle"`
+	APIHost          string          `json:"apiHost,omitempty"`
+	APIKey           string          `json:"-"`                  // 加密保存，只在构建上游请求时解密
+	APIKeyMask       KeyMask         `json:"apiKeyMask"`         // Key 的脱敏信息
+	ProxyURL         string          `json:"proxyUrl,omitempty"` // 出站代理，为空时使用全局配置
+	Enabled          bool            `json:"enabled"`
+	AllowCustomKey   bool            `json:"allowCustomKey"`
+	Models           []ProviderModel `json:"models,omitempty"`
+	AutoImportModels bool            `json:"autoImportModels"` // 定时同步上游模型列表时自动导入新增的模型
+	IsDefault        bool            `json:"isDefault"`
+	SortOrder        int             `json:"sortOrder"`
+	CreatedAt        time.Time       `json:"createdAt"`
+	UpdatedAt        time.Time       `json:"updatedAt"`
 }
 
 // PublicProvider 公开的 Provider 信息 (隐藏 API Key)
@@ -62,6 +95,16 @@ func (p *Provider) ToPublic() PublicProvider {
 	}
 }
 
+// FindModel 在 Provider 的模型列表中按 ModelID 查找模型
+func (p *Provider) FindModel(modelID string) *ProviderModel {
+	for i := range p.Models {
+		if p.Models[i].ModelID == modelID {
+			return &p.Models[i]
+		}
+	}
+	return nil
+}
+
 // CreateProvider 创建新的 Provider
 func CreateProvider(p *Provider) (*Provider, error) {
 	modelsJSON, err := json.Marshal(p.Models)
@@ -69,13 +112,19 @@ func CreateProvider(p *Provider) (*Provider, error) {
 		return nil, err
 	}
 
+	apiKey, err := sealKey(p.APIKey, &p.APIKeyMask)
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := database.DB.Exec(`
 		INSERT INTO system_providers 
-		(provider_id, name, api_style, api_host, api_key, enabled, allow_custom_key, models, is_default, sort_order)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, p.ProviderID, p.Name, p.APIStyle, p.APIHost, p.APIKey,
+		(provider_id, name, api_style, api_host, api_key, api_key_last4, api_key_fingerprint, api_key_rotated_at,
+			proxy_url, enabled, allow_custom_key, models, auto_import_models, is_default, sort_order)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.ProviderID, p.Name, p.APIStyle, p.APIHost, apiKey, p.APIKeyMask.Last4, p.APIKeyMask.Fingerprint, p.APIKeyMask.RotatedAt, p.ProxyURL,
 		boolToInt(p.Enabled), boolToInt(p.AllowCustomKey),
-		string(modelsJSON), boolToInt(p.IsDefault), p.SortOrder)
+		string(modelsJSON), boolToInt(p.AutoImportModels), boolToInt(p.IsDefault), p.SortOrder)
 	if err != nil {
 		return nil, err
 	}
@@ -84,22 +133,28 @@ func CreateProvider(p *Provider) (*Provider, error) {
 	return GetProviderByID(id)
 }
 
-// UpdateProvider 更新 Provider
+// UpdateProvider 更新 Provider，APIKey 为明文时视为设置新 Key，为空时清除 Key
 func UpdateProvider(p *Provider) error {
 	modelsJSON, err := json.Marshal(p.Models)
 	if err != nil {
 		return err
 	}
+	apiKey, err := sealKey(p.APIKey, &p.APIKeyMask)
+	if err != nil {
+		return err
+	}
 
 	_, err = database.DB.Exec(`
 		UPDATE system_providers SET
 			provider_id = ?, name = ?, api_style = ?, api_host = ?, api_key = ?,
-			enabled = ?, allow_custom_key = ?, models = ?, is_default = ?, sort_order = ?,
+			api_key_last4 = ?, api_key_fingerprint = ?, api_key_rotated_at = ?, proxy_url = ?,
+			enabled = ?, allow_custom_key = ?, models = ?, auto_import_models = ?, is_default = ?, sort_order = ?,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, p.ProviderID, p.Name, p.APIStyle, p.APIHost, p.APIKey,
+	`, p.ProviderID, p.Name, p.APIStyle, p.APIHost, apiKey,
+		p.APIKeyMask.Last4, p.APIKeyMask.Fingerprint, p.APIKeyMask.RotatedAt, p.ProxyURL,
 		boolToInt(p.Enabled), boolToInt(p.AllowCustomKey),
-		string(modelsJSON), boolToInt(p.IsDefault), p.SortOrder, p.ID)
+		string(modelsJSON), boolToInt(p.AutoImportModels), boolToInt(p.IsDefault), p.SortOrder, p.ID)
 	return err
 }
 
@@ -109,25 +164,30 @@ func DeleteProvider(id int64) error {
 	return err
 }
 
-// GetProviderByID 根据 ID 获取 Provider
-func GetProviderByID(id int64) (*Provider, error) {
+// providerColumns system_providers 查询列，与 scanProvider 的顺序一致
+const providerColumns = `id, provider_id, name, api_style, api_host, api_key, api_key_last4, api_key_fingerprint, api_key_rotated_at, proxy_url, enabled,
+	allow_custom_key, models, auto_import_models, is_default, sort_order, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanProvider(row rowScanner) (*Provider, error) {
 	p := &Provider{}
 	var modelsJSON string
-	var enabled, allowCustomKey, isDefault int
-
-	err := database.DB.QueryRow(`
-		SELECT id, provider_id, name, api_style, api_host, api_key, enabled, 
-			   allow_custom_key, models, is_default, sort_order, created_at, updated_at
-		FROM system_providers WHERE id = ?
-	`, id).Scan(&p.ID, &p.ProviderID, &p.Name, &p.APIStyle, &p.APIHost, &p.APIKey,
-		&enabled, &allowCustomKey, &modelsJSON, &isDefault, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt)
-	if err != nil {
+	var enabled, allowCustomKey, autoImportModels, isDefault int
+
+	if err := row.Scan(&p.ID, &p.ProviderID, &p.Name, &p.APIStyle, &p.APIHost, &p.APIKey,
+		&p.APIKeyMask.Last4, &p.APIKeyMask.Fingerprint, &p.APIKeyMask.RotatedAt, &p.ProxyURL, &enabled, &allowCustomKey,
+		&modelsJSON, &autoImportModels, &isDefault, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt); err != nil {
 		return nil, err
 	}
 
 	p.Enabled = enabled == 1
 	p.AllowCustomKey = allowCustomKey == 1
+	p.AutoImportModels = autoImportModels == 1
 	p.IsDefault = isDefault == 1
+	p.APIKeyMask.Set = p.APIKey != ""
 
 	if modelsJSON != "" {
 		json.Unmarshal([]byte(modelsJSON), &p.Models)
@@ -136,14 +196,19 @@ func GetProviderByID(id int64) (*Provider, error) {
 	return p, nil
 }
 
+// GetProviderByID 根据 ID 获取 Provider
+func GetProviderByID(id int64) (*Provider, error) {
+	return scanProvider(database.DB.QueryRow("SELECT "+providerColumns+" FROM system_providers WHERE id = ?", id))
+}
+
 // GetAllProviders 获取所有 Provider (管理员用)
 func GetAllProviders() ([]Provider, error) {
-	return queryProviders("SELECT id, provider_id, name, api_style, api_host, api_key, enabled, allow_custom_key, models, is_default, sort_order, created_at, updated_at FROM system_providers ORDER BY sort_order, id")
+	return queryProviders("SELECT " + providerColumns + " FROM system_providers ORDER BY sort_order, id")
 }
 
 // GetEnabledProviders 获取启用的 Provider (普通用户用)
 func GetEnabledProviders() ([]Provider, error) {
-	return queryProviders("SELECT id, provider_id, name, api_style, api_host, api_key, enabled, allow_custom_key, models, is_default, sort_order, created_at, updated_at FROM system_providers WHERE enabled = 1 ORDER BY sort_order, id")
+	return queryProviders("SELECT " + providerColumns + " FROM system_providers WHERE enabled = 1 ORDER BY sort_order, id")
 }
 
 func queryProviders(query string) ([]Provider, error) {
@@ -155,24 +220,11 @@ func queryProviders(query string) ([]Provider, error) {
 
 	var providers []Provider
 	for rows.Next() {
-		var p Provider
-		var modelsJSON string
-		var enabled, allowCustomKey, isDefault int
-
-		if err := rows.Scan(&p.ID, &p.ProviderID, &p.Name, &p.APIStyle, &p.APIHost, &p.APIKey,
-			&enabled, &allowCustomKey, &modelsJSON, &isDefault, &p.SortOrder, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		p, err := scanProvider(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		p.Enabled = enabled == 1
-		p.AllowCustomKey = allowCustomKey == 1
-		p.IsDefault = isDefault == 1
-
-		if modelsJSON != "" {
-			json.Unmarshal([]byte(modelsJSON), &p.Models)
-		}
-
-		providers = append(providers, p)
+		providers = append(providers, *p)
 	}
 
 	return providers, rows.Err()
@@ -184,3 +236,38 @@ func boolToInt(b bool) int {
 	}
 	return 0
 }
+
+// AddProviderModels 向 Provider 的模型列表追加模型，已存在的 ModelID 会被跳过，返回实际添加的模型
+// 在事务中锁定 Provider 后修改，避免与管理员的编辑或其他同步任务互相覆盖
+func AddProviderModels(id int64, add []ProviderModel) ([]ProviderModel, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := scanProvider(tx.QueryRow("SELECT "+providerColumns+" FROM system_providers WHERE id = ? FOR UPDATE", id))
+	if err != nil {
+		return nil, err
+	}
+
+	var added []ProviderModel
+	for _, m := range add {
+		if p.FindModel(m.ModelID) == nil {
+			p.Models = append(p.Models, m)
+			added = append(added, m)
+		}
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	modelsJSON, err := json.Marshal(p.Models)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE system_providers SET models = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", string(modelsJSON), id); err != nil {
+		return nil, err
+	}
+	return added, tx.Commit()
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"time"
+
+	"chatbox-backend/database"
+)
+
+// ProviderKey Provider 的额外 API Key
+type ProviderKey struct {
+	ID         int64     `json:"id"`
+	ProviderID int64     `json:"providerId"`
+	Name       string    `json:"name"`
+	APIKey     string    `json:"-"`          // 加密保存，只在构建上游请求时解密
+	APIKeyMask KeyMask   `json:"apiKeyMask"` // Key 的脱敏信息
+	Weight     int       `json:"weight"`
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+const providerKeyColumns = "id, provider_id, name, api_key, api_key_last4, api_key_fingerprint, api_key_rotated_at, weight, enabled, created_at, updated_at"
+
+func scanProviderKey(row rowScanner) (*ProviderKey, error) {
+	k := &ProviderKey{}
+	var enabled int
+	if err := row.Scan(&k.ID, &k.ProviderID, &k.Name, &k.APIKey, &k.APIKeyMask.Last4, &k.APIKeyMask.Fingerprint,
+		&k.APIKeyMask.RotatedAt, &k.Weight, &enabled, &k.CreatedAt, &k.UpdatedAt); err != nil {
+		return nil, err
+	}
+	k.Enabled = enabled == 1
+	k.APIKeyMask.Set = k.APIKey != ""
+	return k, nil
+}
+
+// CreateProviderKey 添加 API Key
+func CreateProviderKey(k *ProviderKey) (*ProviderKey, error) {
+	apiKey, err := sealKey(k.APIKey, &k.APIKeyMask)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.DB.Exec(`
+		INSERT INTO provider_api_keys (provider_id, name, api_key, api_key_last4, api_key_fingerprint, api_key_rotated_at, weight, enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, k.ProviderID, k.Name, apiKey, k.APIKeyMask.Last4, k.APIKeyMask.Fingerprint, k.APIKeyMask.RotatedAt, k.Weight, boolToInt(k.Enabled))
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	return GetProviderKeyByID(id)
+}
+
+// UpdateProviderKey 更新 API Key
+func UpdateProviderKey(k *ProviderKey) error {
+	apiKey, err := sealKey(k.APIKey, &k.APIKeyMask)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec(`
+		UPDATE provider_api_keys SET name = ?, api_key = ?, api_key_last4 = ?, api_key_fingerprint = ?, api_key_rotated_at = ?,
+			weight = ?, enabled = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, k.Name, apiKey, k.APIKeyMask.Last4, k.APIKeyMask.Fingerprint, k.APIKeyMask.RotatedAt, k.Weight, boolToInt(k.Enabled), k.ID)
+	return err
+}
+
+// DeleteProviderKey 删除 API Key
+func DeleteProviderKey(id int64) error {
+	_, err := database.DB.Exec("DELETE FROM provider_api_keys WHERE id = ?", id)
+	return err
+}
+
+// GetProviderKeyByID 根据 ID 获取 API Key
+func GetProviderKeyByID(id int64) (*ProviderKey, error) {
+	return scanProviderKey(database.DB.QueryRow("SELECT "+providerKeyColumns+" FROM provider_api_keys WHERE id = ?", id))
+}
+
+// GetProviderKeys 获取 Provider 的所有 API Key (管理员用)
+func GetProviderKeys(providerID int64) ([]ProviderKey, error) {
+	return queryProviderKeys("SELECT "+providerKeyColumns+" FROM provider_api_keys WHERE provider_id = ? ORDER BY id", providerID)
+}
+
+// GetEnabledProviderKeys 获取 Provider 启用的 API Key
+func GetEnabledProviderKeys(providerID int64) ([]ProviderKey, error) {
+	return queryProviderKeys("SELECT "+providerKeyColumns+" FROM provider_api_keys WHERE provider_id = ? AND enabled = 1 ORDER BY id", providerID)
+}
+
+// GetAllEnabledProviderKeys 获取所有启用的 API Key，按 Provider 分组
+func GetAllEnabledProviderKeys() (map[int64][]ProviderKey, error) {
+	keys, err := queryProviderKeys("SELECT " + providerKeyColumns + " FROM provider_api_keys WHERE enabled = 1 ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[int64][]ProviderKey)
+	for _, k := range keys {
+		grouped[k.ProviderID] = append(grouped[k.ProviderID], k)
+	}
+	return grouped, nil
+}
+
+func queryProviderKeys(query string, args ...interface{}) ([]ProviderKey, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []ProviderKey
+	for rows.Next() {
+		k, err := scanProviderKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
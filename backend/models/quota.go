@@ -0,0 +1,164 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"chatbox-backend/database"
+)
+
+// 配额的作用范围与周期
+const (
+	QuotaScopeUser  = "user"
+	QuotaScopeGroup = "group"
+
+	QuotaPeriodDay   = "day"
+	QuotaPeriodMonth = "month"
+)
+
+// Quota 用户或分组在一个周期内的 tokens / 费用上限，字段为 nil 表示不限制
+type Quota struct {
+	ID         int64     `json:"id"`
+	Scope      string    `json:"scope"`  // user | group
+	Target     string    `json:"target"` // 用户 ID 或分组名
+	Period     string    `json:"period"` // day | month
+	TokenLimit *int64    `json:"tokenLimit"`
+	CostLimit  *float64  `json:"costLimit"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// QuotaStatus 配额在当前周期的使用情况
+type QuotaStatus struct {
+	Quota
+	TokensUsed      int64     `json:"tokensUsed"`
+	CostUsed        float64   `json:"costUsed"`
+	TokensRemaining *int64    `json:"tokensRemaining"`
+	CostRemaining   *float64  `json:"costRemaining"`
+	PeriodStart     time.Time `json:"periodStart"`
+	ResetsAt        time.Time `json:"resetsAt"`
+	Exceeded        bool      `json:"exceeded"`
+}
+
+const quotaColumns = "id, scope, target, period, token_limit, cost_limit, created_at, updated_at"
+
+func scanQuota(row rowScanner) (*Quota, error) {
+	q := &Quota{}
+	if err := row.Scan(&q.ID, &q.Scope, &q.Target, &q.Period, &q.TokenLimit, &q.CostLimit, &q.CreatedAt, &q.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// SaveQuota 创建或更新配额 (scope + target + period 唯一)
+func SaveQuota(q *Quota) (*Quota, error) {
+	_, err := database.DB.Exec(`
+		INSERT INTO quotas (scope, target, period, token_limit, cost_limit)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE token_limit = VALUES(token_limit), cost_limit = VALUES(cost_limit),
+			updated_at = CURRENT_TIMESTAMP
+	`, q.Scope, q.Target, q.Period, q.TokenLimit, q.CostLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanQuota(database.DB.QueryRow(
+		"SELECT "+quotaColumns+" FROM quotas WHERE scope = ? AND target = ? AND period = ?", q.Scope, q.Target, q.Period,
+	))
+}
+
+// DeleteQuota 删除配额
+func DeleteQuota(id int64) error {
+	_, err := database.DB.Exec("DELETE FROM quotas WHERE id = ?", id)
+	return err
+}
+
+// GetAllQuotas 获取所有配额
+func GetAllQuotas() ([]Quota, error) {
+	return queryQuotas("SELECT " + quotaColumns + " FROM quotas ORDER BY scope, target, period")
+}
+
+// GetUserQuotaStatuses 获取对用户生效的配额 (本人配额与所在分组的配额) 及当前周期的使用情况
+// 只统计使用系统 Key 的请求，个人 Key 的用量不占用配额
+func GetUserQuotaStatuses(user *User) ([]QuotaStatus, error) {
+	quotas, err := queryQuotas(
+		"SELECT "+quotaColumns+" FROM quotas WHERE (scope = ? AND target = ?) OR (scope = ? AND target = ? AND target <> '') ORDER BY scope DESC, period",
+		QuotaScopeUser, strconv.FormatInt(user.ID, 10), QuotaScopeGroup, user.Group,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	statuses := make([]QuotaStatus, 0, len(quotas))
+	for _, q := range quotas {
+		st := QuotaStatus{Quota: q}
+		st.PeriodStart, st.ResetsAt = QuotaPeriod(q.Period, now)
+
+		if q.Scope == QuotaScopeGroup {
+			err = database.DB.QueryRow(`
+				SELECT COALESCE(SUM(r.total_tokens), 0), COALESCE(SUM(r.cost), 0)
+				FROM usage_records r JOIN users u ON u.id = r.user_id
+				WHERE u.user_group = ? AND r.created_at >= ? AND r.key_source = ?
+			`, q.Target, st.PeriodStart, KeySourceSystem).Scan(&st.TokensUsed, &st.CostUsed)
+		} else {
+			err = database.DB.QueryRow(`
+				SELECT COALESCE(SUM(total_tokens), 0), COALESCE(SUM(cost), 0)
+				FROM usage_records WHERE user_id = ? AND created_at >= ? AND key_source = ?
+			`, user.ID, st.PeriodStart, KeySourceSystem).Scan(&st.TokensUsed, &st.CostUsed)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if q.TokenLimit != nil {
+			remaining := *q.TokenLimit - st.TokensUsed
+			if remaining <= 0 {
+				remaining = 0
+				st.Exceeded = true
+			}
+			st.TokensRemaining = &remaining
+		}
+		if q.CostLimit != nil {
+			remaining := *q.CostLimit - st.CostUsed
+			if remaining <= 0 {
+				remaining = 0
+				st.Exceeded = true
+			}
+			st.CostRemaining = &remaining
+		}
+
+		statuses = append(statuses, st)
+	}
+	return statuses, nil
+}
+
+// QuotaPeriod 返回 at 所在周期的开始时间与重置时间 (按服务器时区的自然日 / 自然月)
+func QuotaPeriod(period string, at time.Time) (start, end time.Time) {
+	y, m, d := at.Date()
+	if period == QuotaPeriodDay {
+		start = time.Date(y, m, d, 0, 0, 0, 0, at.Location())
+		return start, start.AddDate(0, 0, 1)
+	}
+	start = time.Date(y, m, 1, 0, 0, 0, 0, at.Location())
+	return start, start.AddDate(0, 1, 0)
+}
+
+func queryQuotas(query string, args ...interface{}) ([]Quota, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quotas []Quota
+	for rows.Next() {
+		q, err := scanQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, *q)
+	}
+
+	return quotas, rows.Err()
+}
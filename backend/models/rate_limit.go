@@ -0,0 +1,103 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"chatbox-backend/database"
+)
+
+// 限流配置的作用范围
+const (
+	RateLimitScopeRole = "role"
+	RateLimitScopeUser = "user"
+)
+
+// RateLimit 角色或单个用户的限流配置
+// 字段为 nil 时沿用上一级配置 (用户 → 角色 → 全局)，0 表示不限制
+type RateLimit struct {
+	ID                int64     `json:"id"`
+	Scope             string    `json:"scope"`  // role | user
+	Target            string    `json:"target"` // 角色名或用户 ID
+	RequestsPerMinute *int      `json:"requestsPerMinute"`
+	TokensPerMinute   *int      `json:"tokensPerMinute"`
+	CreatedAt         time.Time `json:"createdAt"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+}
+
+const rateLimitColumns = "id, scope, target, requests_per_minute, tokens_per_minute, created_at, updated_at"
+
+func scanRateLimit(row rowScanner) (*RateLimit, error) {
+	r := &RateLimit{}
+	if err := row.Scan(&r.ID, &r.Scope, &r.Target, &r.RequestsPerMinute, &r.TokensPerMinute, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SaveRateLimit 创建或更新限流配置 (scope + target 唯一)
+func SaveRateLimit(r *RateLimit) (*RateLimit, error) {
+	_, err := database.DB.Exec(`
+		INSERT INTO rate_limits (scope, target, requests_per_minute, tokens_per_minute)
+		VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE requests_per_minute = VALUES(requests_per_minute),
+			tokens_per_minute = VALUES(tokens_per_minute), updated_at = CURRENT_TIMESTAMP
+	`, r.Scope, r.Target, r.RequestsPerMinute, r.TokensPerMinute)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanRateLimit(database.DB.QueryRow(
+		"SELECT "+rateLimitColumns+" FROM rate_limits WHERE scope = ? AND target = ?", r.Scope, r.Target,
+	))
+}
+
+// DeleteRateLimit 删除限流配置
+func DeleteRateLimit(id int64) error {
+	_, err := database.DB.Exec("DELETE FROM rate_limits WHERE id = ?", id)
+	return err
+}
+
+// GetAllRateLimits 获取所有限流配置
+func GetAllRateLimits() ([]RateLimit, error) {
+	return queryRateLimits("SELECT " + rateLimitColumns + " FROM rate_limits ORDER BY scope, target")
+}
+
+// GetUserRateLimits 获取对用户生效的限流配置 (角色配置与用户配置)
+func GetUserRateLimits(userID int64, role string) (roleLimit, userLimit *RateLimit, err error) {
+	limits, err := queryRateLimits(
+		"SELECT "+rateLimitColumns+" FROM rate_limits WHERE (scope = ? AND target = ?) OR (scope = ? AND target = ?)",
+		RateLimitScopeRole, role, RateLimitScopeUser, strconv.FormatInt(userID, 10),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range limits {
+		if limits[i].Scope == RateLimitScopeRole {
+			roleLimit = &limits[i]
+		} else {
+			userLimit = &limits[i]
+		}
+	}
+	return roleLimit, userLimit, nil
+}
+
+func queryRateLimits(query string, args ...interface{}) ([]RateLimit, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var limits []RateLimit
+	for rows.Next() {
+		r, err := scanRateLimit(rows)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, *r)
+	}
+
+	return limits, rows.Err()
+}
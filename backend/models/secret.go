@@ -0,0 +1,165 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"chatbox-backend/database"
+	"chatbox-backend/secret"
+)
+
+// KeyMask API Key 的脱敏信息，管理员接口只返回该信息，不返回 Key 本身
+type KeyMask struct {
+	Set         bool       `json:"set"`
+	Last4       string     `json:"last4,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"` // 明文 SHA-256 的前 16 位，用于比对是否为同一个 Key
+	RotatedAt   *time.Time `json:"rotatedAt,omitempty"`   // 最近一次设置 Key 的时间
+}
+
+// newKeyMask 根据明文生成脱敏信息，Key 过短时不保存最后 4 位
+func newKeyMask(plaintext string) KeyMask {
+	sum := sha256.Sum256([]byte(plaintext))
+	mask := KeyMask{Set: true, Fingerprint: hex.EncodeToString(sum[:8])}
+	if len(plaintext) > 8 {
+		mask.Last4 = plaintext[len(plaintext)-4:]
+	}
+	return mask
+}
+
+// ErrInvalidAPIKey 待保存的 API Key 带有密文前缀但无法用当前密钥解密 (不是由本服务加密的值)
+var ErrInvalidAPIKey = errors.New("API key must not start with enc:")
+
+// checkSealed 确认带有密文前缀的值确实可以用密钥配置解密，避免把伪造的密文当作已加密的 Key 保存
+func checkSealed(value string) error {
+	if _, err := secret.Decrypt(value); err != nil {
+		return ErrInvalidAPIKey
+	}
+	return nil
+}
+
+// sealKey 加密待保存的 API Key 并更新脱敏信息
+// 明文视为新设置的 Key，空值视为清除，已加密的值 (未修改的 Key) 保持原样
+func sealKey(value string, mask *KeyMask) (string, error) {
+	if value == "" {
+		*mask = KeyMask{}
+		return "", nil
+	}
+	if secret.IsEncrypted(value) {
+		return value, checkSealed(value)
+	}
+
+	sealed, err := secret.Encrypt(value)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	*mask = newKeyMask(value)
+	mask.RotatedAt = &now
+	return sealed, nil
+}
+
+// secretColumns 保存加密 API Key 的表与列，masked 表示同时保存了脱敏信息 (api_key_last4 / api_key_fingerprint)
+var secretColumns = []struct {
+	table, column string
+	masked        bool
+}{
+	{"system_providers", "api_key", true},
+	{"provider_api_keys", "api_key", true},
+	{"user_provider_keys", "api_key", false},
+}
+
+// sealSecret 加密待保存的 API Key，空值与已加密的值原样返回
+func sealSecret(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	if secret.IsEncrypted(value) {
+		return value, checkSealed(value)
+	}
+	return secret.Encrypt(value)
+}
+
+type pendingSecret struct {
+	table, column string
+	masked        bool
+	id            int64
+	value         string
+}
+
+// pendingSecrets 查找尚未加密、不是由当前主密钥加密或缺少脱敏信息的 API Key
+func pendingSecrets() ([]pendingSecret, error) {
+	var pending []pendingSecret
+	for _, sc := range secretColumns {
+		fingerprint := "'-'"
+		if sc.masked {
+			fingerprint = "api_key_fingerprint"
+		}
+		rows, err := database.DB.Query("SELECT id, " + sc.column + ", " + fingerprint + " FROM " + sc.table + " WHERE " + sc.column + " IS NOT NULL AND " + sc.column + " <> ''")
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			p := pendingSecret{table: sc.table, column: sc.column, masked: sc.masked}
+			var fp string
+			if err := rows.Scan(&p.id, &p.value, &fp); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if secret.NeedsRotation(p.value) || fp == "" {
+				pending = append(pending, p)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pending, nil
+}
+
+// ErrUndecryptableSecrets 部分 API Key 无法解密 (加密它们的旧密钥已从配置中删除)，其余 Key 已正常处理
+var ErrUndecryptableSecrets = errors.New("some API keys could not be decrypted")
+
+// ReencryptSecrets 使用当前主密钥加密所有明文 API Key、重新加密由旧密钥加密的 Key，并补全缺少的脱敏信息，返回更新的数量
+// 旧密钥需要仍在密钥配置中才能解密；无法解密的 Key 会被跳过，最后返回 ErrUndecryptableSecrets
+func ReencryptSecrets() (int, error) {
+	pending, err := pendingSecrets()
+	if err != nil {
+		return 0, err
+	}
+
+	updated, failed := 0, 0
+	for _, p := range pending {
+		plaintext, err := secret.Reveal(p.value)
+		if err != nil {
+			log.Printf("[Secret] %s id=%d: %v", p.table, p.id, err)
+			failed++
+			continue
+		}
+		sealed, err := secret.Encrypt(plaintext)
+		if err != nil {
+			return updated, err
+		}
+		// 只在值未被并发修改时更新
+		if p.masked {
+			mask := newKeyMask(plaintext)
+			_, err = database.DB.Exec("UPDATE "+p.table+" SET "+p.column+" = ?, api_key_last4 = ?, api_key_fingerprint = ? WHERE id = ? AND "+p.column+" = ?",
+				sealed, mask.Last4, mask.Fingerprint, p.id, p.value)
+		} else {
+			_, err = database.DB.Exec("UPDATE "+p.table+" SET "+p.column+" = ? WHERE id = ? AND "+p.column+" = ?", sealed, p.id, p.value)
+		}
+		if err != nil {
+			return updated, err
+		}
+		updated++
+	}
+	if failed > 0 {
+		return updated, fmt.Errorf("%w: %d key(s)", ErrUndecryptableSecrets, failed)
+	}
+	return updated, nil
+}
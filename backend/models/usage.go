@@ -0,0 +1,373 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatbox-backend/database"
+)
+
+// UsageRecord 一次代理请求的 tokens 用量
+type UsageRecord struct {
+	ID               int64     `json:"id"`
+	UserID           int64     `json:"userId"`
+	ProviderID       int64     `json:"providerId"` // system_providers.id
+	Provider         string    `json:"provider"`   // Provider ID 字符串
+	Model            string    `json:"model"`
+	Endpoint         string    `json:"endpoint"`  // chat | image | embedding | rerank | anthropic | google
+	KeySource        string    `json:"keySource"` // system | user (个人 Key)
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	CachedTokens     int       `json:"cachedTokens"` // 缓存命中的输入 tokens，包含在 PromptTokens 中
+	TotalTokens      int       `json:"totalTokens"`
+	ImageCount       int       `json:"imageCount"`
+	Cost             float64   `json:"cost"`
+	LatencyMs        int64     `json:"latencyMs"`
+	StatusCode       int       `json:"statusCode"`   // 流式响应开始后上游出错时记为 502
+	ErrorMessage     string    `json:"errorMessage"` // 流式响应开始后上游返回的错误或中断原因
+	Stream           bool      `json:"stream"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// CreateUsageRecord 保存用量记录
+func CreateUsageRecord(r *UsageRecord) error {
+	_, err := database.DB.Exec(`
+		INSERT INTO usage_records (user_id, provider_id, provider, model, endpoint, key_source, prompt_tokens, completion_tokens,
+			cached_tokens, total_tokens, image_count, cost, latency_ms, status_code, error_message, stream)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.UserID, r.ProviderID, r.Provider, r.Model, r.Endpoint, r.KeySource, r.PromptTokens, r.CompletionTokens,
+		r.CachedTokens, r.TotalTokens, r.ImageCount, r.Cost, r.LatencyMs, r.StatusCode, r.ErrorMessage, boolToInt(r.Stream))
+	return err
+}
+
+// UsageFilter 用量统计的筛选条件，时间范围为 [From, To)，其余字段为空时不筛选
+type UsageFilter struct {
+	From      time.Time
+	To        time.Time
+	UserID    int64
+	Group     string
+	Provider  string
+	Model     string
+	KeySource string // system | user
+	Status    string // success (<400) | error (>=400) | 具体状态码
+}
+
+// where 生成筛选条件，表别名 r 为 usage_records，u 为 users
+func (f UsageFilter) where() (string, []interface{}, error) {
+	conds := []string{"r.created_at >= ?", "r.created_at < ?"}
+	args := []interface{}{f.From, f.To}
+
+	if f.UserID > 0 {
+		conds = append(conds, "r.user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if f.Group != "" {
+		conds = append(conds, "u.user_group = ?")
+		args = append(args, f.Group)
+	}
+	if f.Provider != "" {
+		conds = append(conds, "r.provider = ?")
+		args = append(args, f.Provider)
+	}
+	if f.Model != "" {
+		conds = append(conds, "r.model = ?")
+		args = append(args, f.Model)
+	}
+	if f.KeySource != "" {
+		conds = append(conds, "r.key_source = ?")
+		args = append(args, f.KeySource)
+	}
+	switch f.Status {
+	case "":
+	case "success":
+		conds = append(conds, "r.status_code < 400")
+	case "error":
+		conds = append(conds, "r.status_code >= 400")
+	default:
+		code, err := strconv.Atoi(f.Status)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid status filter: %s", f.Status)
+		}
+		conds = append(conds, "r.status_code = ?")
+		args = append(args, code)
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// UsageAggregate 按某个维度汇总的用量与费用
+type UsageAggregate struct {
+	Key              string  `json:"key"`
+	Label            string  `json:"label,omitempty"` // 用户维度为用户名
+	Requests         int64   `json:"requests"`
+	Errors           int64   `json:"errors"` // 状态码 >= 400 的请求数
+	ErrorRate        float64 `json:"errorRate"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	CachedTokens     int64   `json:"cachedTokens"`
+	TotalTokens      int64   `json:"totalTokens"`
+	ImageCount       int64   `json:"imageCount"`
+	Cost             float64 `json:"cost"`
+	AvgLatencyMs     float64 `json:"avgLatencyMs"`
+}
+
+// Add 累加另一组汇总数据，用于计算合计
+func (a *UsageAggregate) Add(other UsageAggregate) {
+	totalLatency := a.AvgLatencyMs*float64(a.Requests) + other.AvgLatencyMs*float64(other.Requests)
+	a.Requests += other.Requests
+	a.Errors += other.Errors
+	a.PromptTokens += other.PromptTokens
+	a.CompletionTokens += other.CompletionTokens
+	a.CachedTokens += other.CachedTokens
+	a.TotalTokens += other.TotalTokens
+	a.ImageCount += other.ImageCount
+	a.Cost += other.Cost
+	if a.Requests > 0 {
+		a.AvgLatencyMs = totalLatency / float64(a.Requests)
+		a.ErrorRate = float64(a.Errors) / float64(a.Requests)
+	}
+}
+
+// usageGroupColumns 汇总维度对应的分组表达式与显示名称表达式
+var usageGroupColumns = map[string][2]string{
+	"user":      {"CAST(r.user_id AS CHAR)", "COALESCE(MAX(u.username), '')"},
+	"group":     {"COALESCE(u.user_group, '')", "''"},
+	"provider":  {"r.provider", "''"},
+	"model":     {"CONCAT(r.provider, '/', r.model)", "''"},
+	"day":       {"DATE_FORMAT(r.created_at, '%Y-%m-%d')", "''"},
+	"hour":      {"DATE_FORMAT(r.created_at, '%Y-%m-%d %H:00')", "''"},
+	"keySource": {"r.key_source", "''"},
+}
+
+// usageAggregateColumns 汇总查询的统计列，AggregateUsage 在前面加上分组列 (因此排序列序号从 3 开始)
+const usageAggregateColumns = `COUNT(*), COALESCE(SUM(r.status_code >= 400), 0),
+			COALESCE(AVG(r.latency_ms), 0), COALESCE(SUM(r.prompt_tokens), 0), COALESCE(SUM(r.completion_tokens), 0),
+			COALESCE(SUM(r.cached_tokens), 0), COALESCE(SUM(r.image_count), 0), COALESCE(SUM(r.total_tokens), 0),
+			COALESCE(SUM(r.cost), 0)`
+
+// usageSortColumns 排序字段对应的列序号 (见 AggregateUsage 的查询)
+var usageSortColumns = map[string]string{
+	"cost":     "11",
+	"tokens":   "10",
+	"requests": "3",
+	"errors":   "4",
+}
+
+// IsValidUsageGroupBy 检查汇总维度是否支持
+func IsValidUsageGroupBy(groupBy string) bool {
+	_, ok := usageGroupColumns[groupBy]
+	return ok
+}
+
+// IsValidUsageSort 检查排序字段是否支持
+func IsValidUsageSort(sort string) bool {
+	_, ok := usageSortColumns[sort]
+	return ok
+}
+
+// UsageQuery 用量汇总查询
+type UsageQuery struct {
+	GroupBy string // user | group | provider | model | day | hour | keySource
+	Filter  UsageFilter
+	Sort    string // cost | tokens | requests | errors，按时间汇总时固定按时间升序
+	Limit   int    // 大于 0 时只返回前 N 项
+}
+
+// AggregateUsage 按维度汇总用量、费用与错误数
+func AggregateUsage(q UsageQuery) ([]UsageAggregate, error) {
+	cols, ok := usageGroupColumns[q.GroupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported group by: %s", q.GroupBy)
+	}
+	where, args, err := q.Filter.where()
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy := "1"
+	if q.GroupBy != "day" && q.GroupBy != "hour" {
+		sortCol, ok := usageSortColumns[q.Sort]
+		if !ok {
+			sortCol = usageSortColumns["cost"]
+		}
+		orderBy = sortCol + " DESC, 3 DESC"
+	}
+
+	query := `
+		SELECT ` + cols[0] + ` AS k, ` + cols[1] + `, ` + usageAggregateColumns + `
+		FROM usage_records r LEFT JOIN users u ON u.id = r.user_id` + where + `
+		GROUP BY k
+		ORDER BY ` + orderBy
+	if q.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(q.Limit)
+	}
+
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []UsageAggregate{}
+	for rows.Next() {
+		var a UsageAggregate
+		if err := rows.Scan(a.scanFields(&a.Key, &a.Label)...); err != nil {
+			return nil, err
+		}
+		a.computeErrorRate()
+		items = append(items, a)
+	}
+
+	return items, rows.Err()
+}
+
+// TotalUsage 汇总符合条件的全部用量，不受 UsageQuery.Limit 的影响
+func TotalUsage(f UsageFilter) (UsageAggregate, error) {
+	total := UsageAggregate{Key: "total"}
+	where, args, err := f.where()
+	if err != nil {
+		return total, err
+	}
+
+	err = database.DB.QueryRow(`
+		SELECT `+usageAggregateColumns+`
+		FROM usage_records r LEFT JOIN users u ON u.id = r.user_id`+where, args...).Scan(total.scanFields()...)
+	if err != nil {
+		return total, err
+	}
+	total.computeErrorRate()
+	return total, nil
+}
+
+// scanFields 按 usageAggregateColumns 的顺序返回扫描目标，prefix 为之前的分组列
+func (a *UsageAggregate) scanFields(prefix ...interface{}) []interface{} {
+	return append(prefix, &a.Requests, &a.Errors, &a.AvgLatencyMs, &a.PromptTokens,
+		&a.CompletionTokens, &a.CachedTokens, &a.ImageCount, &a.TotalTokens, &a.Cost)
+}
+
+func (a *UsageAggregate) computeErrorRate() {
+	if a.Requests > 0 {
+		a.ErrorRate = float64(a.Errors) / float64(a.Requests)
+	}
+}
+
+// UsageStatusCount 某个维度下单个错误状态码的请求数
+type UsageStatusCount struct {
+	Key        string
+	StatusCode int
+	Count      int64
+}
+
+// CountUsageErrors 按维度与状态码统计失败请求 (状态码 >= 400)
+func CountUsageErrors(groupBy string, f UsageFilter) ([]UsageStatusCount, error) {
+	cols, ok := usageGroupColumns[groupBy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported group by: %s", groupBy)
+	}
+	where, args, err := f.where()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT `+cols[0]+` AS k, r.status_code, COUNT(*)
+		FROM usage_records r LEFT JOIN users u ON u.id = r.user_id`+where+` AND r.status_code >= 400
+		GROUP BY k, r.status_code
+		ORDER BY 3 DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []UsageStatusCount
+	for rows.Next() {
+		var sc UsageStatusCount
+		if err := rows.Scan(&sc.Key, &sc.StatusCode, &sc.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, sc)
+	}
+
+	return counts, rows.Err()
+}
+
+// UsageRecordDetail 带用户名与分组的用量记录，用于导出
+type UsageRecordDetail struct {
+	UsageRecord
+	Username string `json:"username"`
+	Group    string `json:"group"`
+}
+
+// EachUsageRecord 按时间顺序逐条读取符合条件的用量记录，fn 返回错误时停止读取
+// 记录不会一次性加载到内存中，适合导出大量数据
+func EachUsageRecord(f UsageFilter, fn func(*UsageRecordDetail) error) error {
+	where, args, err := f.where()
+	if err != nil {
+		return err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT r.id, r.user_id, COALESCE(u.username, ''), COALESCE(u.user_group, ''), r.provider_id, r.provider,
+			r.model, r.endpoint, r.key_source, r.prompt_tokens, r.completion_tokens, r.cached_tokens, r.total_tokens,
+			r.image_count, r.cost, r.latency_ms, r.status_code, r.error_message, r.stream, r.created_at
+		FROM usage_records r LEFT JOIN users u ON u.id = r.user_id`+where+`
+		ORDER BY r.created_at, r.id
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var d UsageRecordDetail
+	for rows.Next() {
+		var stream int
+		if err := rows.Scan(&d.ID, &d.UserID, &d.Username, &d.Group, &d.ProviderID, &d.Provider,
+			&d.Model, &d.Endpoint, &d.KeySource, &d.PromptTokens, &d.CompletionTokens, &d.CachedTokens, &d.TotalTokens,
+			&d.ImageCount, &d.Cost, &d.LatencyMs, &d.StatusCode, &d.ErrorMessage, &stream, &d.CreatedAt); err != nil {
+			return err
+		}
+		d.Stream = stream == 1
+		if err := fn(&d); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetRecentUsageRecords 获取符合条件的最近 limit 条用量记录，按时间倒序
+func GetRecentUsageRecords(f UsageFilter, limit int) ([]UsageRecord, error) {
+	where, args, err := f.where()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.Query(`
+		SELECT r.id, r.user_id, r.provider_id, r.provider, r.model, r.endpoint, r.key_source, r.prompt_tokens, r.completion_tokens,
+			r.cached_tokens, r.total_tokens, r.image_count, r.cost, r.latency_ms, r.status_code, r.error_message, r.stream, r.created_at
+		FROM usage_records r LEFT JOIN users u ON u.id = r.user_id`+where+`
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT `+strconv.Itoa(limit), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []UsageRecord{}
+	for rows.Next() {
+		var r UsageRecord
+		var stream int
+		if err := rows.Scan(&r.ID, &r.UserID, &r.ProviderID, &r.Provider, &r.Model, &r.Endpoint, &r.KeySource, &r.PromptTokens,
+			&r.CompletionTokens, &r.CachedTokens, &r.TotalTokens, &r.ImageCount, &r.Cost, &r.LatencyMs,
+			&r.StatusCode, &r.ErrorMessage, &stream, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Stream = stream == 1
+		records = append(records, r)
+	}
+
+	return records, rows.Err()
+}
@@ -10,6 +10,7 @@ type User struct {
 	Username        string    `json:"username"`
 	PasswordHash    string    `json:"-"` // 不在 JSON 中暴露
 	Role            string    `json:"role"`
+	Group           string    `json:"group"` // 用户分组 (部门)，用于共享配额与统计
 	PasswordChanged bool      `json:"password_changed"`
 	CreatedAt       time.Time `json:"created_at"`
 }
@@ -18,6 +19,7 @@ type UserResponse struct {
 	ID              int64     `json:"id"`
 	Username        string    `json:"username"`
 	Role            string    `json:"role"`
+	Group           string    `json:"group"`
 	PasswordChanged bool      `json:"password_changed"`
 	CreatedAt       time.Time `json:"created_at"`
 }
@@ -27,6 +29,7 @@ func (u *User) ToResponse() UserResponse {
 		ID:              u.ID,
 		Username:        u.Username,
 		Role:            u.Role,
+		Group:           u.Group,
 		PasswordChanged: u.PasswordChanged,
 		CreatedAt:       u.CreatedAt,
 	}
@@ -37,9 +40,9 @@ func GetUserByID(id int64) (*User, error) {
 	user := &User{}
 	var passwordChanged int
 	err := database.DB.QueryRow(
-		"SELECT id, username, password_hash, role, password_changed, created_at FROM users WHERE id = ?",
+		"SELECT id, username, password_hash, role, user_group, password_changed, created_at FROM users WHERE id = ?",
 		id,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &passwordChanged, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Group, &passwordChanged, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -52,9 +55,9 @@ func GetUserByUsername(username string) (*User, error) {
 	user := &User{}
 	var passwordChanged int
 	err := database.DB.QueryRow(
-		"SELECT id, username, password_hash, role, password_changed, created_at FROM users WHERE username = ?",
+		"SELECT id, username, password_hash, role, user_group, password_changed, created_at FROM users WHERE username = ?",
 		username,
-	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &passwordChanged, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Group, &passwordChanged, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -72,7 +75,7 @@ func GetUserCount() (int, error) {
 // GetAllUsers 获取所有用户
 func GetAllUsers() ([]User, error) {
 	rows, err := database.DB.Query(
-		"SELECT id, username, password_hash, role, password_changed, created_at FROM users ORDER BY created_at DESC",
+		"SELECT id, username, password_hash, role, user_group, password_changed, created_at FROM users ORDER BY created_at DESC",
 	)
 	if err != nil {
 		return nil, err
@@ -83,7 +86,7 @@ func GetAllUsers() ([]User, error) {
 	for rows.Next() {
 		var user User
 		var passwordChanged int
-		if err := rows.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &passwordChanged, &user.CreatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.Group, &passwordChanged, &user.CreatedAt); err != nil {
 			return nil, err
 		}
 		user.PasswordChanged = passwordChanged == 1
@@ -93,6 +96,12 @@ func GetAllUsers() ([]User, error) {
 	return users, rows.Err()
 }
 
+// UpdateUserGroup 设置用户分组，空字符串表示不属于任何分组
+func UpdateUserGroup(userID int64, group string) error {
+	_, err := database.DB.Exec("UPDATE users SET user_group = ? WHERE id = ?", group, userID)
+	return err
+}
+
 // UpdatePassword 更新用户密码
 func UpdatePassword(userID int64, newPasswordHash string) error {
 	_, err := database.DB.Exec(
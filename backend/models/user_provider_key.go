@@ -0,0 +1,99 @@
+package models
+
+import (
+	"chatbox-backend/database"
+	"time"
+)
+
+// 用量记录中 API Key 的来源
+const (
+	KeySourceSystem = "system" // 系统配置的 Key
+	KeySourceUser   = "user"   // 用户的个人 Key
+)
+
+// UserProviderKey 用户为允许自定义 Key 的 Provider 保存的个人 API Key
+// APIKey 为加密后的值，只在代理构建上游请求时解密
+type UserProviderKey struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"userId"`
+	ProviderID     int64     `json:"providerId"`
+	APIKey         string    `json:"-"`
+	KeyHint        string    `json:"keyHint"`        // Key 的最后 4 位，用于展示
+	SystemFallback bool      `json:"systemFallback"` // 个人 Key 失败时是否改用系统 Key
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+const userProviderKeyColumns = "id, user_id, provider_id, api_key, key_hint, system_fallback, created_at, updated_at"
+
+func scanUserProviderKey(row rowScanner) (*UserProviderKey, error) {
+	k := &UserProviderKey{}
+	var systemFallback int
+	if err := row.Scan(&k.ID, &k.UserID, &k.ProviderID, &k.APIKey, &k.KeyHint, &systemFallback, &k.CreatedAt, &k.UpdatedAt); err != nil {
+		return nil, err
+	}
+	k.SystemFallback = systemFallback == 1
+	return k, nil
+}
+
+// SaveUserProviderKey 创建或更新用户的个人 Key (user_id + provider_id 唯一)，Key 加密后保存
+func SaveUserProviderKey(k *UserProviderKey) (*UserProviderKey, error) {
+	apiKey, err := sealSecret(k.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = database.DB.Exec(`
+		INSERT INTO user_provider_keys (user_id, provider_id, api_key, key_hint, system_fallback)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE api_key = VALUES(api_key), key_hint = VALUES(key_hint),
+			system_fallback = VALUES(system_fallback), updated_at = CURRENT_TIMESTAMP
+	`, k.UserID, k.ProviderID, apiKey, k.KeyHint, boolToInt(k.SystemFallback))
+	if err != nil {
+		return nil, err
+	}
+
+	return GetUserProviderKey(k.UserID, k.ProviderID)
+}
+
+// UpdateUserProviderKeyFallback 只更新个人 Key 失败时是否改用系统 Key
+func UpdateUserProviderKeyFallback(userID, providerID int64, systemFallback bool) error {
+	_, err := database.DB.Exec(`
+		UPDATE user_provider_keys SET system_fallback = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND provider_id = ?
+	`, boolToInt(systemFallback), userID, providerID)
+	return err
+}
+
+// DeleteUserProviderKey 删除用户的个人 Key
+func DeleteUserProviderKey(userID, providerID int64) error {
+	_, err := database.DB.Exec("DELETE FROM user_provider_keys WHERE user_id = ? AND provider_id = ?", userID, providerID)
+	return err
+}
+
+// GetUserProviderKey 获取用户在指定 Provider 上的个人 Key
+func GetUserProviderKey(userID, providerID int64) (*UserProviderKey, error) {
+	return scanUserProviderKey(database.DB.QueryRow(
+		"SELECT "+userProviderKeyColumns+" FROM user_provider_keys WHERE user_id = ? AND provider_id = ?", userID, providerID,
+	))
+}
+
+// GetUserProviderKeys 获取用户的所有个人 Key
+func GetUserProviderKeys(userID int64) ([]UserProviderKey, error) {
+	rows, err := database.DB.Query("SELECT "+userProviderKeyColumns+" FROM user_provider_keys WHERE user_id = ? ORDER BY provider_id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []UserProviderKey
+	for rows.Next() {
+		k, err := scanUserProviderKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
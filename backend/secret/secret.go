@@ -0,0 +1,171 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"chatbox-backend/config"
+)
+
+// prefix 加密值的前缀，完整格式为 enc:<密钥 ID>:<base64(nonce + 密文)>
+const prefix = "enc:"
+
+var (
+	mu sync.RWMutex
+	// activeID 当前用于加密的主密钥 ID，其余密钥只用于解密 (轮换期间的旧密钥)
+	activeID string
+	keys     = map[string]cipher.AEAD{}
+)
+
+// ErrNotInitialized 未调用 Init 时加解密返回的错误
+var ErrNotInitialized = errors.New("secret: not initialized")
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Init 根据配置初始化 AES-GCM 主密钥，在启动时调用
+// 主密钥取自 SECRET_KEY_FILE 指向的文件或 SECRET_KEY，都未配置时返回错误 (不与 JWT_SECRET 共用，避免轮换 JWT 密钥后无法解密)
+// 可以配置多个密钥 (逗号或换行分隔)，格式为 "<密钥 ID>:<密钥>"，第一个用于加密，其余只用于解密；
+// 不带 ID 的密钥使用其摘要的前 8 位作为 ID。密钥可以是任意字符串，经 SHA-256 派生为 256 位密钥
+func Init(c *config.Config) error {
+	material := c.SecretKey
+	if c.SecretKeyFile != "" {
+		data, err := os.ReadFile(c.SecretKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read secret key file: %w", err)
+		}
+		material = string(data)
+	}
+	if strings.TrimSpace(material) == "" {
+		return errors.New("SECRET_KEY or SECRET_KEY_FILE must be set to encrypt API keys")
+	}
+
+	ring := map[string]cipher.AEAD{}
+	var first string
+	for _, entry := range strings.FieldsFunc(material, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, key := "", entry
+		if before, after, ok := strings.Cut(entry, ":"); ok && keyIDPattern.MatchString(before) {
+			id, key = before, after
+		}
+		sum := sha256.Sum256([]byte(key))
+		if id == "" {
+			digest := sha256.Sum256(sum[:])
+			id = hex.EncodeToString(digest[:4])
+		}
+		if _, dup := ring[id]; dup {
+			return fmt.Errorf("duplicate secret key id: %s", id)
+		}
+
+		block, err := aes.NewCipher(sum[:])
+		if err != nil {
+			return err
+		}
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return err
+		}
+		ring[id] = gcm
+		if first == "" {
+			first = id
+		}
+	}
+	if first == "" {
+		return errors.New("no secret key configured")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	keys = ring
+	activeID = first
+	log.Printf("[Secret] loaded %d key(s), active key id %s", len(ring), first)
+	return nil
+}
+
+// ActiveKeyID 返回当前用于加密的主密钥 ID
+func ActiveKeyID() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return activeID
+}
+
+// IsEncrypted 判断值是否为 Encrypt 生成的密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// NeedsRotation 判断值是否需要用当前主密钥重新加密 (明文或由其他密钥加密)，空值不需要
+func NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	id, _, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	return !IsEncrypted(value) || !ok || id != ActiveKeyID()
+}
+
+// Encrypt 使用当前主密钥加密字符串
+func Encrypt(plaintext string) (string, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	aead := keys[activeID]
+	if aead == nil {
+		return "", ErrNotInitialized
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return prefix + activeID + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密 Encrypt 生成的密文，按密文中的密钥 ID 选择主密钥
+func Decrypt(value string) (string, error) {
+	id, data, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !IsEncrypted(value) || !ok {
+		return "", errors.New("secret: invalid ciphertext")
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	if activeID == "" {
+		return "", ErrNotInitialized
+	}
+	aead := keys[id]
+	if aead == nil {
+		return "", fmt.Errorf("secret: value was encrypted with unknown key %s", id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("secret: invalid ciphertext")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("secret: failed to decrypt: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Reveal 返回保存值对应的明文：密文解密后返回，尚未加密的旧数据原样返回
+func Reveal(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	return Decrypt(value)
+}
@@ -0,0 +1,142 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+
+	"chatbox-backend/config"
+)
+
+func initKeys(t *testing.T, material string) {
+	t.Helper()
+	if err := Init(&config.Config{SecretKey: material}); err != nil {
+		t.Fatalf("Init(%q) error = %v", material, err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	initKeys(t, "k1:first-secret")
+
+	for _, plaintext := range []string{"sk-test-1234567890", "", "密钥 with unicode"} {
+		sealed, err := Encrypt(plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt(%q) error = %v", plaintext, err)
+		}
+		if !strings.HasPrefix(sealed, "enc:k1:") {
+			t.Errorf("Encrypt(%q) = %q, want enc:k1: prefix", plaintext, sealed)
+		}
+		got, err := Decrypt(sealed)
+		if err != nil || got != plaintext {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", plaintext, got, err)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	initKeys(t, "k1:old-secret")
+	old, err := Encrypt("sk-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 新密钥在前，旧密钥只用于解密
+	initKeys(t, "k2:new-secret,k1:old-secret")
+	if ActiveKeyID() != "k2" {
+		t.Fatalf("ActiveKeyID() = %q, want k2", ActiveKeyID())
+	}
+	if !NeedsRotation(old) {
+		t.Error("value encrypted with the old key should need rotation")
+	}
+	if got, err := Decrypt(old); err != nil || got != "sk-rotate" {
+		t.Fatalf("Decrypt() with the old key = %q, %v", got, err)
+	}
+
+	rotated, err := Encrypt("sk-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRotation(rotated) {
+		t.Error("value encrypted with the active key should not need rotation")
+	}
+
+	// 删除旧密钥后，由它加密的值无法解密
+	initKeys(t, "k2:new-secret")
+	if _, err := Decrypt(old); err == nil {
+		t.Error("Decrypt() succeeded after the old key was removed")
+	}
+	if got, err := Decrypt(rotated); err != nil || got != "sk-rotate" {
+		t.Errorf("Decrypt() of the rotated value = %q, %v", got, err)
+	}
+}
+
+func TestDecryptInvalidCiphertext(t *testing.T) {
+	initKeys(t, "k1:first-secret")
+	valid, err := Encrypt("sk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := valid[:len(valid)-4] + "AAAA"
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"plaintext", "sk-test"},
+		{"prefix only", "enc:"},
+		{"missing key id", "enc:bm90LWEtY2lwaGVydGV4dA=="},
+		{"unknown key id", "enc:k9:" + strings.TrimPrefix(valid, "enc:k1:")},
+		{"invalid base64", "enc:k1:not base64!"},
+		{"shorter than the nonce", "enc:k1:AAAA"},
+		{"tampered ciphertext", tampered},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Decrypt(tt.value); err == nil {
+				t.Errorf("Decrypt(%q) = %q, want error", tt.value, got)
+			}
+		})
+	}
+}
+
+func TestReveal(t *testing.T) {
+	initKeys(t, "k1:first-secret")
+	sealed, err := Encrypt("sk-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"legacy plaintext is returned as is", "sk-legacy", "sk-legacy"},
+		{"ciphertext is decrypted", sealed, "sk-test"},
+		{"empty value", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Reveal(tt.value); err != nil || got != tt.want {
+				t.Errorf("Reveal(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		material string
+	}{
+		{"empty", ""},
+		{"only separators", " , \n"},
+		{"duplicate key id", "k1:a,k1:b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Init(&config.Config{SecretKey: tt.material}); err == nil {
+				t.Errorf("Init(%q) succeeded, want error", tt.material)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package upstream
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"chatbox-backend/config"
+)
+
+var (
+	mu      sync.Mutex
+	cfg     *config.Config
+	rootCAs *x509.CertPool
+	// 按出站代理地址缓存的共享客户端，"" 为默认客户端
+	clients = map[string]*http.Client{}
+)
+
+// Init 根据配置初始化上游 HTTP 客户端，在启动时调用
+func Init(c *config.Config) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	cfg = c
+	rootCAs = nil
+	clients = map[string]*http.Client{}
+
+	if c.UpstreamCAFile != "" {
+		pem, err := os.ReadFile(c.UpstreamCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificates found in %s", c.UpstreamCAFile)
+		}
+		rootCAs = pool
+	}
+
+	return ValidateProxyURL(c.UpstreamProxy)
+}
+
+// ValidateProxyURL 检查出站代理地址，支持 http / https / socks5 / socks5h，空字符串表示不使用
+func ValidateProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy URL is missing host")
+	}
+	return nil
+}
+
+// Client 返回使用指定出站代理的共享客户端，proxyURL 为空时使用全局配置
+// 客户端不设置整体超时 (流式响应可能持续很久)，由 Transport 的各阶段超时与请求 context 控制
+func Client(proxyURL string) (*http.Client, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if cfg == nil {
+		cfg = config.Load()
+	}
+	if proxyURL == "" {
+		proxyURL = cfg.UpstreamProxy
+	}
+	if client, ok := clients[proxyURL]; ok {
+		return client, nil
+	}
+
+	transport, err := newTransport(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transport}
+	clients[proxyURL] = client
+	return client, nil
+}
+
+func newTransport(proxyURL string) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   cfg.UpstreamDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   cfg.UpstreamMaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.UpstreamIdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.UpstreamTLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.UpstreamResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if rootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+
+	if proxyURL != "" {
+		if err := ValidateProxyURL(proxyURL); err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(proxyURL)
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	return transport, nil
+}
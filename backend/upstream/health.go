@@ -0,0 +1,214 @@
+package upstream
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	CircuitClosed   = "closed"    // 正常放行
+	CircuitOpen     = "open"      // 熔断中，拒绝请求
+	CircuitHalfOpen = "half_open" // 熔断结束，放行一个探测请求
+)
+
+// ErrCircuitOpen Provider 处于熔断状态，请求未发送
+var ErrCircuitOpen = errors.New("upstream circuit is open")
+
+// latencySmoothing 平均延迟的指数移动平均系数
+const latencySmoothing = 0.2
+
+// Stats 上游调用统计
+type Stats struct {
+	Requests            int64      `json:"requests"`
+	Successes           int64      `json:"successes"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	AvgLatencyMs        float64    `json:"avgLatencyMs"` // 到收到响应头为止的平均延迟
+	LastStatus          int        `json:"lastStatus,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	LastSuccessAt       *time.Time `json:"lastSuccessAt,omitempty"`
+	LastFailureAt       *time.Time `json:"lastFailureAt,omitempty"`
+}
+
+// ProviderHealth Provider 的熔断状态与调用统计
+type ProviderHealth struct {
+	State     string          `json:"state"`
+	OpenedAt  *time.Time      `json:"openedAt,omitempty"`
+	RetryAt   *time.Time      `json:"retryAt,omitempty"` // 熔断中时，下一次放行探测请求的时间
+	Stats     Stats           `json:"stats"`
+	Keys      map[int64]Stats `json:"keys"`
+	probing   bool
+	probeFrom time.Time
+}
+
+var (
+	healthMu sync.Mutex
+	// providerID -> 健康状态
+	health = map[int64]*ProviderHealth{}
+)
+
+// circuitSettings 返回熔断阈值与熔断时长
+func circuitSettings() (int, time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	threshold, openFor := 5, 30*time.Second
+	if cfg != nil {
+		if cfg.UpstreamCircuitFailures > 0 {
+			threshold = cfg.UpstreamCircuitFailures
+		}
+		if cfg.UpstreamCircuitOpenDuration > 0 {
+			openFor = cfg.UpstreamCircuitOpenDuration
+		}
+	}
+	return threshold, openFor
+}
+
+// Allow 返回当前是否允许向 Provider 发送请求
+// 熔断时长结束后进入半开状态，同一时间只放行一个探测请求，探测请求长时间未返回时放行下一个
+func Allow(providerID int64) bool {
+	_, openFor := circuitSettings()
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	h, ok := health[providerID]
+	if !ok || h.State == CircuitClosed {
+		return true
+	}
+
+	now := time.Now()
+	if h.State == CircuitOpen {
+		if h.RetryAt != nil && now.Before(*h.RetryAt) {
+			return false
+		}
+		h.State = CircuitHalfOpen
+		h.probing = false
+	}
+	if h.probing && now.Sub(h.probeFrom) < openFor {
+		return false
+	}
+	h.probing = true
+	h.probeFrom = now
+	return true
+}
+
+// RetryAfter 返回 Provider 熔断后多久放行下一个探测请求，未熔断时返回 0
+// 半开状态下探测请求进行中时按熔断时长估算
+func RetryAfter(providerID int64) time.Duration {
+	_, openFor := circuitSettings()
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	h, ok := health[providerID]
+	if !ok || h.State == CircuitClosed {
+		return 0
+	}
+	now := time.Now()
+	if h.State == CircuitOpen && h.RetryAt != nil && now.Before(*h.RetryAt) {
+		return h.RetryAt.Sub(now)
+	}
+	if h.probing {
+		if wait := openFor - now.Sub(h.probeFrom); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Record 记录一次上游调用结果
+// status 为 0 表示网络错误；只有网络错误与 5xx 计入 Provider 的连续失败次数，
+// 401/403/429 属于单个 Key 的问题，由 Key 轮询处理
+func Record(providerID, keyID int64, status int, latency time.Duration, err error) {
+	threshold, openFor := circuitSettings()
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	h := getHealth(providerID)
+	keyStats := h.Keys[keyID]
+
+	failed := err != nil || status >= http.StatusInternalServerError
+	now := time.Now()
+	for _, st := range []*Stats{&h.Stats, &keyStats} {
+		st.Requests++
+		st.LastStatus = status
+		if st.AvgLatencyMs == 0 {
+			st.AvgLatencyMs = float64(latency.Milliseconds())
+		} else {
+			st.AvgLatencyMs += latencySmoothing * (float64(latency.Milliseconds()) - st.AvgLatencyMs)
+		}
+		if failed {
+			st.Failures++
+			st.ConsecutiveFailures++
+			st.LastFailureAt = &now
+			if err != nil {
+				st.LastError = err.Error()
+			} else {
+				st.LastError = http.StatusText(status)
+			}
+		} else {
+			st.Successes++
+			st.ConsecutiveFailures = 0
+			st.LastSuccessAt = &now
+		}
+	}
+	h.Keys[keyID] = keyStats
+
+	switch {
+	case !failed:
+		h.State = CircuitClosed
+		h.probing = false
+		h.OpenedAt = nil
+		h.RetryAt = nil
+	case h.State == CircuitHalfOpen || h.Stats.ConsecutiveFailures >= threshold:
+		// 探测失败或连续失败达到阈值，重新熔断
+		h.State = CircuitOpen
+		h.probing = false
+		retryAt := now.Add(openFor)
+		h.OpenedAt = &now
+		h.RetryAt = &retryAt
+	}
+}
+
+// HealthSnapshot 返回所有 Provider 健康状态的副本
+func HealthSnapshot() map[int64]ProviderHealth {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	now := time.Now()
+	snapshot := make(map[int64]ProviderHealth, len(health))
+	for id, h := range health {
+		cp := *h
+		if cp.State == CircuitOpen && cp.RetryAt != nil && !now.Before(*cp.RetryAt) {
+			cp.State = CircuitHalfOpen
+		}
+		cp.Keys = make(map[int64]Stats, len(h.Keys))
+		for keyID, st := range h.Keys {
+			cp.Keys[keyID] = st
+		}
+		snapshot[id] = cp
+	}
+	return snapshot
+}
+
+// ResetHealth 清除 Provider 的熔断状态与统计，例如管理员修复配置之后
+func ResetHealth(providerID int64) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	delete(health, providerID)
+}
+
+func getHealth(providerID int64) *ProviderHealth {
+	h, ok := health[providerID]
+	if !ok {
+		h = &ProviderHealth{State: CircuitClosed, Keys: map[int64]Stats{}}
+		health[providerID] = h
+	}
+	return h
+}
@@ -0,0 +1,152 @@
+package upstream
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Key 参与轮询的一个 API Key，ID 为 0 表示 Provider 上配置的默认 Key
+type Key struct {
+	ID     int64
+	Value  string // 数据库中保存的值 (通常为密文)，发送请求时才解密
+	Weight int
+	// Personal 用户的个人 Key，总是最先尝试，不参与轮询、冷却与健康统计
+	Personal bool
+}
+
+// 不同失败原因的 Key 冷却时间
+const (
+	authFailureCooldown   = 10 * time.Minute // 401/403: Key 失效或被吊销
+	rateLimitCooldown     = 30 * time.Second // 429: 未返回 Retry-After 时使用
+	serverFailureCooldown = 15 * time.Second // 5xx 与网络错误
+	maxRetryAfterCooldown = 10 * time.Minute
+)
+
+type keyState struct {
+	cooldownUntil time.Time
+	failures      int
+}
+
+var (
+	keyMu sync.Mutex
+	// 每个 Provider 的轮询计数
+	keyCursor = map[int64]uint64{}
+	// providerID -> keyID -> 状态
+	keyStates = map[int64]map[int64]*keyState{}
+)
+
+// OrderKeys 按加权轮询返回本次请求尝试 Key 的顺序
+// 处于冷却中的 Key 排在最后 (按冷却结束时间先后)，全部冷却时仍然会被尝试
+func OrderKeys(providerID int64, keys []Key) []Key {
+	if len(keys) <= 1 {
+		return keys
+	}
+
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	totalWeight := 0
+	for _, k := range keys {
+		totalWeight += keyWeight(k)
+	}
+
+	// 按权重选出本轮的起始 Key，其余 Key 依次排在后面
+	pos := int(keyCursor[providerID] % uint64(totalWeight))
+	keyCursor[providerID]++
+	start := 0
+	for i, k := range keys {
+		if pos < keyWeight(k) {
+			start = i
+			break
+		}
+		pos -= keyWeight(k)
+	}
+
+	ordered := make([]Key, 0, len(keys))
+	for i := range keys {
+		ordered = append(ordered, keys[(start+i)%len(keys)])
+	}
+
+	now := time.Now()
+	states := keyStates[providerID]
+	cooldown := func(k Key) time.Time {
+		if st, ok := states[k.ID]; ok && st.cooldownUntil.After(now) {
+			return st.cooldownUntil
+		}
+		return time.Time{}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return cooldown(ordered[i]).Before(cooldown(ordered[j]))
+	})
+
+	return ordered
+}
+
+func keyWeight(k Key) int {
+	if k.Weight <= 0 {
+		return 1
+	}
+	return k.Weight
+}
+
+// ShouldFailover 上游返回该状态码时是否应该换一个 Key 重试
+func ShouldFailover(status int) bool {
+	return status == http.StatusUnauthorized ||
+		status == http.StatusForbidden ||
+		status == http.StatusTooManyRequests ||
+		status >= http.StatusInternalServerError
+}
+
+// MarkKeyFailure 记录 Key 调用失败，并根据状态码让 Key 暂时退出轮询
+// status 为 0 表示网络错误，retryAfter 为上游 Retry-After 响应头
+func MarkKeyFailure(providerID, keyID int64, status int, retryAfter string) {
+	var cooldown time.Duration
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		cooldown = authFailureCooldown
+	case status == http.StatusTooManyRequests:
+		cooldown = rateLimitCooldown
+		if secs, err := strconv.Atoi(retryAfter); err == nil && secs > 0 {
+			cooldown = time.Duration(secs) * time.Second
+			if cooldown > maxRetryAfterCooldown {
+				cooldown = maxRetryAfterCooldown
+			}
+		}
+	default:
+		cooldown = serverFailureCooldown
+	}
+
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	st := getKeyState(providerID, keyID)
+	st.failures++
+	st.cooldownUntil = time.Now().Add(cooldown)
+}
+
+// MarkKeySuccess 记录 Key 调用成功，清除冷却状态
+func MarkKeySuccess(providerID, keyID int64) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+
+	if states, ok := keyStates[providerID]; ok {
+		delete(states, keyID)
+	}
+}
+
+func getKeyState(providerID, keyID int64) *keyState {
+	states, ok := keyStates[providerID]
+	if !ok {
+		states = map[int64]*keyState{}
+		keyStates[providerID] = states
+	}
+	st, ok := states[keyID]
+	if !ok {
+		st = &keyState{}
+		states[keyID] = st
+	}
+	return st
+}
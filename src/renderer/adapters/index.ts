@@ -5,6 +5,7 @@ import platform from '@/platform'
 import storage from '@/storage'
 import { StorageKeyGenerator } from '@/storage/StoreStorage'
 import * as settingActions from '@/stores/settingActions'
+import { useAuthStore } from '@/stores/authStore'
 import { apiRequest } from '@/utils/request'
 import { RendererSentryAdapter } from './sentry'
 
@@ -58,5 +59,6 @@ export async function createModelDependencies(): Promise<ModelDependencies> {
     },
     sentry: new RendererSentryAdapter(),
     getRemoteConfig: settingActions.getRemoteConfig,
+    getAuthToken: () => useAuthStore.getState().token,
   }
 }
@@ -81,9 +81,9 @@ export function getModel(
   switch (provider) {
     case ModelProviderEnum.EnterAI: {
       // EnterAI uses OpenAI compatible API
-      // 如果没有本地 API Key，使用后端代理
+      // 如果没有本地 API Key，使用后端代理（需携带登录 token）
       const hasLocalApiKey = !!providerSetting.apiKey
-      const apiKey = hasLocalApiKey ? providerSetting.apiKey : 'proxy-placeholder'
+      const apiKey = hasLocalApiKey ? providerSetting.apiKey : dependencies.getAuthToken?.() || 'proxy-placeholder'
       // 后端代理地址：使用 /api/proxy/v1，ai-sdk 会自动添加 /chat/completions 或 /images/generations
       const apiHost = hasLocalApiKey ? (formattedApiHost || 'https://api.openai.com') : '/api/proxy/v1'
       
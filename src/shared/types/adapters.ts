@@ -29,4 +29,6 @@ export interface ModelDependencies {
   storage: StorageAdapter
   sentry: SentryAdapter
   getRemoteConfig(): any
+  // 当前登录用户的 JWT，用于调用需要认证的后端代理
+  getAuthToken?(): string | null
 } 
\ No newline at end of file